@@ -0,0 +1,754 @@
+package core
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+	"github.com/tylerb/graceful"
+)
+
+// AdminPrefix is the path the admin api is mounted under on the internal
+// servers, when it is not served on its own listener.
+const AdminPrefix = "/_gatekeeper"
+
+type adminError struct {
+	msg  string
+	code int
+}
+
+func (e adminError) Error() string {
+	return e.msg
+}
+
+var (
+	AdminInternalErr = adminError{"INTERNAL_ERROR", 500}
+
+	AdminInvalidUpstreamParamsErr = adminError{"INVALID_UPSTREAM_PARAMS", 400}
+	AdminInvalidBackendParamsErr  = adminError{"INVALID_BACKEND_PARAMS", 400}
+	AdminUpstreamNotFoundErr      = adminError{"UPSTREAM_NOT_FOUND", 404}
+	AdminBackendNotFoundErr       = adminError{"BACKEND_NOT_FOUND", 404}
+	AdminDuplicateUpstreamErr     = adminError{"DUPLICATE_UPSTREAM", 409}
+	AdminDuplicateBackendErr      = adminError{"DUPLICATE_BACKEND", 409}
+	AdminRouteTableUnavailableErr = adminError{"ROUTE_TABLE_UNAVAILABLE", 404}
+	AdminRouteConflictErr         = adminError{"ROUTE_CONFLICT", 409}
+	AdminUpstreamNotInSplitErr    = adminError{"UPSTREAM_NOT_IN_SPLIT", 400}
+
+	adminErrMapping = map[error]adminError{
+		UpstreamNotFoundError: AdminUpstreamNotFoundErr,
+		BackendNotFoundError:  AdminBackendNotFoundErr,
+		DuplicateUpstreamErr:  AdminDuplicateUpstreamErr,
+		DuplicateBackendErr:   AdminDuplicateBackendErr,
+		BackendAddressErr:     AdminInvalidBackendParamsErr,
+		RouteConflictErr:      AdminRouteConflictErr,
+		UpstreamNotInSplitErr: AdminUpstreamNotInSplitErr,
+		InvalidBackendTLSErr:  AdminInvalidUpstreamParamsErr,
+
+		gatekeeper.InvalidHostnameErr: AdminInvalidUpstreamParamsErr,
+		gatekeeper.InvalidRuleErr:     AdminInvalidUpstreamParamsErr,
+	}
+)
+
+// routeTabler is implemented by routers which are able to expose their
+// routing table, such as the localRouter.
+type routeTabler interface {
+	RouteTable() *RouteTable
+}
+
+// NewAdminHandler returns an http.Handler serving a JSON api for inspecting
+// the running app; its upstreams, backends, plugins, routing table and
+// options, as well as adding and removing upstreams and backends.
+func NewAdminHandler(options Options, upstreamManager UpstreamManager, router Router, plugins PluginManagerContainer) http.Handler {
+	a := &admin{
+		options:         options,
+		upstreamManager: upstreamManager,
+		router:          router,
+		plugins:         plugins,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /upstreams", a.fetchUpstreamsHandler)
+	mux.HandleFunc("POST /upstreams", a.addUpstreamHandler)
+	mux.HandleFunc("GET /upstreams/{upstream_id}", a.fetchUpstreamHandler)
+	mux.HandleFunc("DELETE /upstreams/{upstream_id}", a.removeUpstreamHandler)
+	mux.HandleFunc("PUT /upstreams/{upstream_id}/weight", a.setUpstreamWeightHandler)
+	mux.HandleFunc("POST /upstreams/{upstream_id}/backends", a.addBackendHandler)
+	mux.HandleFunc("DELETE /backends/{backend_id}", a.removeBackendHandler)
+
+	mux.HandleFunc("GET /plugins", a.fetchPluginsHandler)
+	mux.HandleFunc("GET /routes", a.fetchRoutesHandler)
+	mux.HandleFunc("GET /options", a.fetchOptionsHandler)
+	return mux
+}
+
+type admin struct {
+	options         Options
+	upstreamManager UpstreamManager
+	router          Router
+	plugins         PluginManagerContainer
+}
+
+func (a *admin) fetchUpstreamsHandler(rw http.ResponseWriter, req *http.Request) {
+	upstreams := a.upstreamManager.Upstreams()
+
+	formattedUpstreams := make([]*adminUpstream, len(upstreams))
+	for idx, upstream := range upstreams {
+		formattedUpstreams[idx] = toAdminUpstream(upstream, a.upstreamManager.Backends(upstream.ID))
+	}
+
+	writeAdminResponse(rw, 200, formattedUpstreams)
+}
+
+func (a *admin) fetchUpstreamHandler(rw http.ResponseWriter, req *http.Request) {
+	upstreamID := gatekeeper.UpstreamID(req.PathValue("upstream_id"))
+	for _, upstream := range a.upstreamManager.Upstreams() {
+		if upstream.ID == upstreamID {
+			writeAdminResponse(rw, 200, toAdminUpstream(upstream, a.upstreamManager.Backends(upstreamID)))
+			return
+		}
+	}
+
+	writeAdminErrorResponse(rw, UpstreamNotFoundError)
+}
+
+// add an upstream, along with any backends in the request body. An ID is
+// generated for the upstream and any backends when one is not given.
+func (a *admin) addUpstreamHandler(rw http.ResponseWriter, req *http.Request) {
+	var rawUpstream adminUpstream
+	if err := json.NewDecoder(req.Body).Decode(&rawUpstream); err != nil {
+		writeAdminErrorResponse(rw, AdminInvalidUpstreamParamsErr)
+		return
+	}
+
+	upstream, backends, err := parseAdminUpstream(&rawUpstream)
+	if err != nil {
+		writeAdminErrorResponse(rw, AdminInvalidUpstreamParamsErr)
+		return
+	}
+
+	if err := a.upstreamManager.AddUpstream(upstream); err != nil {
+		writeAdminErrorResponse(rw, err)
+		return
+	}
+
+	// the upstream is only added along with all of its backends, so it is
+	// removed again when any of them fail to be added
+	for idx, backend := range backends {
+		if err := a.upstreamManager.AddBackend(upstream.ID, backend); err != nil {
+			for _, added := range backends[:idx] {
+				a.upstreamManager.RemoveBackend(added.ID)
+			}
+			a.upstreamManager.RemoveUpstream(upstream.ID)
+
+			writeAdminErrorResponse(rw, err)
+			return
+		}
+	}
+
+	writeAdminResponse(rw, 201, toAdminUpstream(upstream, backends))
+}
+
+// remove an upstream and all of its backends
+func (a *admin) removeUpstreamHandler(rw http.ResponseWriter, req *http.Request) {
+	upstreamID := gatekeeper.UpstreamID(req.PathValue("upstream_id"))
+
+	for _, backend := range a.upstreamManager.Backends(upstreamID) {
+		if err := a.upstreamManager.RemoveBackend(backend.ID); err != nil {
+			writeAdminErrorResponse(rw, err)
+			return
+		}
+	}
+
+	if err := a.upstreamManager.RemoveUpstream(upstreamID); err != nil {
+		writeAdminErrorResponse(rw, err)
+		return
+	}
+
+	writeAdminResponse(rw, 200, "OK")
+}
+
+// change the weight of an upstream in a traffic split
+func (a *admin) setUpstreamWeightHandler(rw http.ResponseWriter, req *http.Request) {
+	upstreamID := gatekeeper.UpstreamID(req.PathValue("upstream_id"))
+
+	var rawWeight adminWeight
+	if err := json.NewDecoder(req.Body).Decode(&rawWeight); err != nil {
+		writeAdminErrorResponse(rw, AdminInvalidUpstreamParamsErr)
+		return
+	}
+
+	if err := a.upstreamManager.SetUpstreamWeight(upstreamID, rawWeight.Weight); err != nil {
+		writeAdminErrorResponse(rw, err)
+		return
+	}
+
+	writeAdminResponse(rw, 200, "OK")
+}
+
+func (a *admin) addBackendHandler(rw http.ResponseWriter, req *http.Request) {
+	upstreamID := gatekeeper.UpstreamID(req.PathValue("upstream_id"))
+
+	var rawBackend adminBackend
+	if err := json.NewDecoder(req.Body).Decode(&rawBackend); err != nil {
+		writeAdminErrorResponse(rw, AdminInvalidBackendParamsErr)
+		return
+	}
+
+	backend := parseAdminBackend(&rawBackend)
+	if err := a.upstreamManager.AddBackend(upstreamID, backend); err != nil {
+		writeAdminErrorResponse(rw, err)
+		return
+	}
+
+	writeAdminResponse(rw, 201, toAdminBackend(backend))
+}
+
+func (a *admin) removeBackendHandler(rw http.ResponseWriter, req *http.Request) {
+	backendID := gatekeeper.BackendID(req.PathValue("backend_id"))
+	if err := a.upstreamManager.RemoveBackend(backendID); err != nil {
+		writeAdminErrorResponse(rw, err)
+		return
+	}
+
+	writeAdminResponse(rw, 200, "OK")
+}
+
+func (a *admin) fetchPluginsHandler(rw http.ResponseWriter, req *http.Request) {
+	plugins := make([]*adminPlugin, 0)
+	for _, managers := range a.plugins {
+		for _, manager := range managers {
+			plugins = append(plugins, toAdminPlugin(manager))
+		}
+	}
+
+	writeAdminResponse(rw, 200, plugins)
+}
+
+// fetch the routing table, which is only available when the router is able
+// to expose it; routers backed by a plugin are not.
+func (a *admin) fetchRoutesHandler(rw http.ResponseWriter, req *http.Request) {
+	tabler, ok := a.router.(routeTabler)
+	if !ok {
+		writeAdminErrorResponse(rw, AdminRouteTableUnavailableErr)
+		return
+	}
+
+	table := tabler.RouteTable()
+	writeAdminResponse(rw, 200, &adminRouteTable{
+		Prefixes:  table.Prefixes,
+		Hostnames: table.Hostnames,
+	})
+}
+
+func (a *admin) fetchOptionsHandler(rw http.ResponseWriter, req *http.Request) {
+	writeAdminResponse(rw, 200, toAdminOptions(a.options))
+}
+
+// adminUpstream is a JSON formatted representation of a gatekeeper.Upstream
+type adminUpstream struct {
+	ID        string                 `json:"upstream_id"`
+	Name      string                 `json:"name"`
+	Protocols []string               `json:"protocols"`
+	Hostnames []string               `json:"hostnames"`
+	Prefixes  []string               `json:"prefixes"`
+	Timeout   time.Duration          `json:"timeout"`
+	Extra     map[string]interface{} `json:"extra"`
+
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host"`
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout"`
+	ConnectTimeout        time.Duration `json:"connect_timeout"`
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout"`
+	Streaming             bool          `json:"streaming"`
+	BackendProtocol       string        `json:"backend_protocol"`
+	Port                  uint          `json:"port"`
+	ProxyProtocol         uint          `json:"proxy_protocol"`
+	ClientAuth            string        `json:"client_auth"`
+	Split                 string        `json:"split"`
+	Weight                uint          `json:"weight"`
+
+	BackendTLS adminBackendTLS `json:"backend_tls"`
+	Rules      []*adminRule    `json:"rules"`
+	Backends   []*adminBackend `json:"backends"`
+}
+
+// adminBackendTLS is a JSON formatted representation of a gatekeeper.BackendTLS
+type adminBackendTLS struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// adminRule is a JSON formatted representation of a gatekeeper.Rule
+type adminRule struct {
+	Methods []string          `json:"methods"`
+	Headers []*adminFieldRule `json:"headers"`
+	Query   []*adminFieldRule `json:"query"`
+	Cookies []*adminFieldRule `json:"cookies"`
+}
+
+type adminFieldRule struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Regex string `json:"regex"`
+}
+
+func toAdminRules(rules []gatekeeper.Rule) []*adminRule {
+	formatted := make([]*adminRule, len(rules))
+	for idx, rule := range rules {
+		formatted[idx] = &adminRule{
+			Methods: rule.Methods,
+			Headers: toAdminFieldRules(rule.Headers),
+			Query:   toAdminFieldRules(rule.Query),
+			Cookies: toAdminFieldRules(rule.Cookies),
+		}
+	}
+	return formatted
+}
+
+func toAdminFieldRules(rules []gatekeeper.FieldRule) []*adminFieldRule {
+	formatted := make([]*adminFieldRule, len(rules))
+	for idx, rule := range rules {
+		formatted[idx] = &adminFieldRule{
+			Name:  rule.Name,
+			Value: rule.Value,
+			Regex: rule.Regex,
+		}
+	}
+	return formatted
+}
+
+func parseAdminRules(rules []*adminRule) []gatekeeper.Rule {
+	parsed := make([]gatekeeper.Rule, len(rules))
+	for idx, rule := range rules {
+		parsed[idx] = gatekeeper.Rule{
+			Methods: rule.Methods,
+			Headers: parseAdminFieldRules(rule.Headers),
+			Query:   parseAdminFieldRules(rule.Query),
+			Cookies: parseAdminFieldRules(rule.Cookies),
+		}
+	}
+	return parsed
+}
+
+func parseAdminFieldRules(rules []*adminFieldRule) []gatekeeper.FieldRule {
+	parsed := make([]gatekeeper.FieldRule, len(rules))
+	for idx, rule := range rules {
+		parsed[idx] = gatekeeper.FieldRule{
+			Name:  rule.Name,
+			Value: rule.Value,
+			Regex: rule.Regex,
+		}
+	}
+	return parsed
+}
+
+func toAdminUpstream(u *gatekeeper.Upstream, backends []*gatekeeper.Backend) *adminUpstream {
+	protocols := make([]string, len(u.Protocols))
+	for idx, protocol := range u.Protocols {
+		protocols[idx] = protocol.String()
+	}
+
+	formattedBackends := make([]*adminBackend, len(backends))
+	for idx, backend := range backends {
+		formattedBackends[idx] = toAdminBackend(backend)
+	}
+
+	return &adminUpstream{
+		ID:        string(u.ID),
+		Name:      u.Name,
+		Protocols: protocols,
+		Hostnames: u.Hostnames,
+		Prefixes:  u.Prefixes,
+		Timeout:   u.Timeout,
+		Extra:     u.Extra,
+
+		MaxIdleConnsPerHost:   u.MaxIdleConnsPerHost,
+		IdleConnTimeout:       u.IdleConnTimeout,
+		ConnectTimeout:        u.ConnectTimeout,
+		TLSHandshakeTimeout:   u.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: u.ResponseHeaderTimeout,
+		Streaming:             u.Streaming,
+		BackendProtocol:       u.BackendProtocol.String(),
+		Port:                  u.Port,
+		ProxyProtocol:         u.ProxyProtocol,
+		ClientAuth:            u.ClientAuth.String(),
+		Split:                 u.Split,
+		Weight:                u.Weight,
+
+		BackendTLS: adminBackendTLS(u.BackendTLS),
+		Rules:      toAdminRules(u.Rules),
+		Backends:   formattedBackends,
+	}
+}
+
+func parseAdminUpstream(u *adminUpstream) (*gatekeeper.Upstream, []*gatekeeper.Backend, error) {
+	protocols, err := gatekeeper.NewProtocols(u.Protocols)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backendProtocol, err := gatekeeper.ParseBackendProtocol(u.BackendProtocol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientAuth, err := gatekeeper.ParseClientAuth(u.ClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	upstream := &gatekeeper.Upstream{
+		ID:        gatekeeper.UpstreamID(u.ID),
+		Name:      u.Name,
+		Protocols: protocols,
+		Hostnames: u.Hostnames,
+		Prefixes:  u.Prefixes,
+		Timeout:   u.Timeout,
+		Extra:     u.Extra,
+
+		MaxIdleConnsPerHost:   u.MaxIdleConnsPerHost,
+		IdleConnTimeout:       u.IdleConnTimeout,
+		ConnectTimeout:        u.ConnectTimeout,
+		TLSHandshakeTimeout:   u.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: u.ResponseHeaderTimeout,
+		Streaming:             u.Streaming,
+		BackendProtocol:       backendProtocol,
+		Port:                  u.Port,
+		ProxyProtocol:         u.ProxyProtocol,
+		ClientAuth:            clientAuth,
+		BackendTLS:            gatekeeper.BackendTLS(u.BackendTLS),
+		Rules:                 parseAdminRules(u.Rules),
+		Split:                 u.Split,
+		Weight:                u.Weight,
+	}
+	if upstream.ID == gatekeeper.NilUpstreamID {
+		upstream.ID = gatekeeper.NewUpstreamID()
+	}
+
+	backends := make([]*gatekeeper.Backend, len(u.Backends))
+	for idx, backend := range u.Backends {
+		backends[idx] = parseAdminBackend(backend)
+	}
+
+	return upstream, backends, nil
+}
+
+// adminBackend is a JSON formatted representation of a gatekeeper.Backend
+type adminBackend struct {
+	ID      string                 `json:"backend_id"`
+	Address string                 `json:"address"`
+	Extra   map[string]interface{} `json:"extra"`
+}
+
+func toAdminBackend(b *gatekeeper.Backend) *adminBackend {
+	return &adminBackend{
+		ID:      string(b.ID),
+		Address: b.Address,
+		Extra:   b.Extra,
+	}
+}
+
+func parseAdminBackend(b *adminBackend) *gatekeeper.Backend {
+	backend := &gatekeeper.Backend{
+		ID:      gatekeeper.BackendID(b.ID),
+		Address: b.Address,
+		Extra:   b.Extra,
+	}
+	if backend.ID == gatekeeper.NilBackendID {
+		backend.ID = gatekeeper.NewBackendID()
+	}
+	return backend
+}
+
+// adminPlugin is a JSON formatted representation of a PluginManager
+type adminPlugin struct {
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Cmd           string    `json:"cmd"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Restarts      uint      `json:"restarts"`
+}
+
+func toAdminPlugin(manager PluginManager) *adminPlugin {
+	return &adminPlugin{
+		Name:          manager.Name(),
+		Type:          manager.Type().String(),
+		Cmd:           manager.Cmd(),
+		LastHeartbeat: manager.LastHeartbeat(),
+		Restarts:      manager.Restarts(),
+	}
+}
+
+// adminOptions is a JSON formatted representation of the Options. Plugin
+// arguments often hold credentials, such as api tokens, so only their names
+// are exposed.
+type adminOptions struct {
+	RouterPlugin           string   `json:"router_plugin"`
+	RouterPluginArgs       []string `json:"router_plugin_args"`
+	UseLocalRouter         bool     `json:"use_local_router"`
+	LoadBalancerPlugin     string   `json:"load_balancer_plugin"`
+	LoadBalancerPluginArgs []string `json:"load_balancer_plugin_args"`
+	UseLocalLoadBalancer   bool     `json:"use_local_load_balancer"`
+	UpstreamPlugins        []string `json:"upstream_plugins"`
+	UpstreamPluginArgs     []string `json:"upstream_plugin_args"`
+	ModifierPlugins        []string `json:"modifier_plugins"`
+	ModifierPluginArgs     []string `json:"modifier_plugin_args"`
+	MetricPlugins          []string `json:"metric_plugins"`
+	MetricPluginArgs       []string `json:"metric_plugin_args"`
+
+	MetricBufferSize    uint          `json:"metric_buffer_size"`
+	MetricFlushInterval time.Duration `json:"metric_flush_interval"`
+
+	Listeners      []*adminListener `json:"listeners"`
+	AdminListener  *adminListener   `json:"admin_listener"`
+	HealthListener *adminListener   `json:"health_listener"`
+
+	TLSCertFiles      []string      `json:"tls_cert_files"`
+	TLSMinVersion     string        `json:"tls_min_version"`
+	TLSCipherSuites   []string      `json:"tls_cipher_suites"`
+	TLSReloadInterval time.Duration `json:"tls_reload_interval"`
+	TLSClientCAFile   string        `json:"tls_client_ca_file"`
+	HTTP2             bool          `json:"http2"`
+	H2C               bool          `json:"h2c"`
+
+	TCPListenHost    string `json:"tcp_listen_host"`
+	TCPProxyProtocol bool   `json:"tcp_proxy_protocol"`
+
+	DefaultProxyTimeout      time.Duration `json:"default_proxy_timeout"`
+	DefaultTCPConnectTimeout time.Duration `json:"default_tcp_connect_timeout"`
+	DefaultDNSTimeout        time.Duration `json:"default_dns_timeout"`
+	PluginTimeout            time.Duration `json:"plugin_timeout"`
+	ProfilerInterval         time.Duration `json:"profiler_interval"`
+	UpgradeTimeout           time.Duration `json:"upgrade_timeout"`
+	AdminOnInternal          bool          `json:"admin_on_internal"`
+}
+
+// adminListener is a JSON formatted representation of a ListenerConfig
+type adminListener struct {
+	Protocol       string   `json:"protocol"`
+	Network        string   `json:"network"`
+	Address        string   `json:"address"`
+	ProxyProtocol  bool     `json:"proxy_protocol"`
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+func toAdminOptions(options Options) *adminOptions {
+	listeners := make([]*adminListener, len(options.Listeners))
+	for idx, listener := range options.Listeners {
+		listeners[idx] = toAdminListener(listener)
+	}
+
+	var adminListener, healthListener *adminListener
+	if options.AdminListener != nil {
+		adminListener = toAdminListener(*options.AdminListener)
+	}
+	if options.HealthListener != nil {
+		healthListener = toAdminListener(*options.HealthListener)
+	}
+
+	certFiles := make([]string, len(options.TLSCertificates))
+	for idx, certificate := range options.TLSCertificates {
+		certFiles[idx] = certificate.CertFile
+	}
+
+	var minVersion string
+	if options.TLSMinVersion != 0 {
+		minVersion = tls.VersionName(options.TLSMinVersion)
+	}
+
+	cipherSuites := make([]string, len(options.TLSCipherSuites))
+	for idx, cipherSuite := range options.TLSCipherSuites {
+		cipherSuites[idx] = tls.CipherSuiteName(cipherSuite)
+	}
+
+	return &adminOptions{
+		RouterPlugin:           options.RouterPlugin,
+		RouterPluginArgs:       adminPluginArgNames(options.RouterPluginArgs),
+		UseLocalRouter:         options.UseLocalRouter,
+		LoadBalancerPlugin:     options.LoadBalancerPlugin,
+		LoadBalancerPluginArgs: adminPluginArgNames(options.LoadBalancerPluginArgs),
+		UseLocalLoadBalancer:   options.UseLocalLoadBalancer,
+		UpstreamPlugins:        options.UpstreamPlugins,
+		UpstreamPluginArgs:     adminPluginArgNames(options.UpstreamPluginArgs),
+		ModifierPlugins:        options.ModifierPlugins,
+		ModifierPluginArgs:     adminPluginArgNames(options.ModifierPluginArgs),
+		MetricPlugins:          options.MetricPlugins,
+		MetricPluginArgs:       adminPluginArgNames(options.MetricPluginArgs),
+
+		MetricBufferSize:    options.MetricBufferSize,
+		MetricFlushInterval: options.MetricFlushInterval,
+
+		Listeners:      listeners,
+		AdminListener:  adminListener,
+		HealthListener: healthListener,
+
+		TLSCertFiles:      certFiles,
+		TLSMinVersion:     minVersion,
+		TLSCipherSuites:   cipherSuites,
+		TLSReloadInterval: options.TLSReloadInterval,
+		TLSClientCAFile:   options.TLSClientCAFile,
+		HTTP2:             options.HTTP2,
+		H2C:               options.H2C,
+
+		TCPListenHost:    options.TCPListenHost,
+		TCPProxyProtocol: options.TCPProxyProtocol != nil,
+
+		DefaultProxyTimeout:      options.DefaultProxyTimeout,
+		DefaultTCPConnectTimeout: options.DefaultTCPConnectTimeout,
+		DefaultDNSTimeout:        options.DefaultDNSTimeout,
+		PluginTimeout:            options.PluginTimeout,
+		ProfilerInterval:         options.ProfilerInterval,
+		UpgradeTimeout:           options.UpgradeTimeout,
+		AdminOnInternal:          options.AdminOnInternal,
+	}
+}
+
+func toAdminListener(listener ListenerConfig) *adminListener {
+	trustedProxies := make([]string, len(listener.TrustedProxies))
+	for idx, cidr := range listener.TrustedProxies {
+		trustedProxies[idx] = cidr.String()
+	}
+
+	return &adminListener{
+		Protocol:       listener.Protocol.String(),
+		Network:        listener.Network,
+		Address:        listener.Address,
+		ProxyProtocol:  listener.ProxyProtocol != nil,
+		TrustedProxies: trustedProxies,
+	}
+}
+
+// adminPluginArgNames returns the sorted names of the plugin arguments,
+// leaving out their values
+func adminPluginArgNames(args map[string]interface{}) []string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// adminWeight is the JSON body for changing an upstream's weight in a traffic
+// split
+type adminWeight struct {
+	Weight uint `json:"weight"`
+}
+
+// adminRouteTable is a JSON formatted representation of a RouteTable
+type adminRouteTable struct {
+	Prefixes  map[string][]gatekeeper.UpstreamID `json:"prefixes"`
+	Hostnames map[string][]gatekeeper.UpstreamID `json:"hostnames"`
+}
+
+type adminErrorResponse struct {
+	Msg string `json:"message"`
+}
+
+// writeAdminErrorResponse maps known errors to an adminError, so that they
+// are written with a meaningful status code; anything else is a 500.
+func writeAdminErrorResponse(rw http.ResponseWriter, err error) {
+	if mappedErr, ok := adminErrMapping[err]; ok {
+		err = mappedErr
+	}
+
+	code := 500
+	if adminErr, ok := err.(adminError); ok {
+		code = adminErr.code
+	}
+
+	// errors wrapping a mapped error, such as a RouteConflictErr naming
+	// the route, keep their message and take the mapped error's code
+	for target, mappedErr := range adminErrMapping {
+		if errors.Is(err, target) {
+			code = mappedErr.code
+		}
+	}
+
+	writeAdminResponse(rw, code, &adminErrorResponse{
+		Msg: err.Error(),
+	})
+}
+
+func writeAdminResponse(rw http.ResponseWriter, code int, val interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(val)
+}
+
+// NewAdminServer returns a server which serves the admin api, or the health
+// checks, on its own listener rather than on the internal servers.
+func NewAdminServer(listener ListenerConfig, handler http.Handler) *AdminServer {
+	return &AdminServer{
+		listenerConfig: listener,
+		handler:        handler,
+	}
+}
+
+type AdminServer struct {
+	listenerConfig ListenerConfig
+	handler        http.Handler
+
+	httpServer *graceful.Server
+	listener   net.Listener
+	errCh      chan error
+}
+
+func (a *AdminServer) Start() error {
+	listener, err := listen(a.listenerConfig)
+	if err != nil {
+		return err
+	}
+	a.listener = listener
+
+	a.httpServer = &graceful.Server{
+		Server: &http.Server{
+			Handler: a.handler,
+		},
+		NoSignalHandling: true,
+	}
+
+	a.errCh = make(chan error, 1)
+	go func() {
+		log.Println("admin listening on: ", a.listenerConfig)
+		a.errCh <- a.httpServer.Serve(listener)
+	}()
+
+	return nil
+}
+
+func (a *AdminServer) Stop(duration time.Duration) error {
+	if a.httpServer == nil {
+		return nil
+	}
+
+	a.httpServer.Stop(duration)
+	<-a.errCh
+	return nil
+}
+
+func (a *AdminServer) ListenerConfig() ListenerConfig {
+	return a.listenerConfig
+}
+
+func (a *AdminServer) ListenerFile() (*os.File, error) {
+	if a.listener == nil {
+		return nil, ServerNotStartedError
+	}
+
+	return listenerFile(a.listener)
+}
+
+func (a *AdminServer) ListenerHandedOff() {
+	keepSocketPath(a.listener)
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminAddUpstream_rollsBackFailedBackends(t *testing.T) {
+	manager := NewUpstreamManager(NewBroadcaster(), NewMetricWriter(10, time.Second))
+	handler := NewAdminHandler(Options{}, manager, nil, nil)
+
+	body := `{"upstream_id": "billing", "protocols": ["http-public"], "prefixes": ["billing"], "backends": [
+		{"backend_id": "billing-1", "address": "http://10.0.0.1:8000"},
+		{"backend_id": "billing-1", "address": "http://10.0.0.2:8000"}
+	]}`
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("POST", "/upstreams", strings.NewReader(body)))
+	if rw.Code != 409 {
+		t.Fatalf("expected a 409, got %d %s", rw.Code, rw.Body)
+	}
+
+	if upstreams := manager.Upstreams(); len(upstreams) != 0 {
+		t.Fatalf("expected the upstream to be removed, got %v", upstreams)
+	}
+	if backends := manager.Backends("billing"); len(backends) != 0 {
+		t.Fatalf("expected the backends to be removed, got %v", backends)
+	}
+}
+
+func TestAdminAddUpstream_rejectsInvalidUpstreams(t *testing.T) {
+	manager := NewUpstreamManager(NewBroadcaster(), NewMetricWriter(10, time.Second))
+	handler := NewAdminHandler(Options{}, manager, nil, nil)
+
+	testCases := []struct {
+		body string
+		msg  string
+	}{
+		{`{"upstream_id": "billing", "protocols": ["http-public"], "hostnames": ["api.*.example.com"]}`, "invalid hostname: api.*.example.com"},
+		{`{"upstream_id": "billing", "protocols": ["http-public"], "hostnames": ["~(unclosed"]}`, "invalid hostname: ~(unclosed"},
+		{`{"upstream_id": "billing", "protocols": ["http-public"], "prefixes": ["billing"], "rules": [{"headers": [{"value": "v2"}]}]}`, "invalid rule: field name required"},
+		{`{"upstream_id": "billing", "protocols": ["http-public"], "prefixes": ["billing"], "rules": [{"headers": [{"name": "X-Version", "regex": "(unclosed"}]}]}`, "invalid rule: X-Version"},
+	}
+
+	for _, testCase := range testCases {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("POST", "/upstreams", strings.NewReader(testCase.body)))
+		if rw.Code != 400 || !strings.Contains(rw.Body.String(), testCase.msg) {
+			t.Fatalf("%s: expected a 400 with %q, got %d %s", testCase.body, testCase.msg, rw.Code, rw.Body)
+		}
+	}
+
+	if upstreams := manager.Upstreams(); len(upstreams) != 0 {
+		t.Fatalf("expected no upstreams to be added, got %v", upstreams)
+	}
+}
+
+func TestAdminFetchOptions_redactsPluginArgs(t *testing.T) {
+	options := Options{
+		UpstreamPlugins:    []string{"consul-upstreams"},
+		UpstreamPluginArgs: map[string]interface{}{"consul-token": "s3cr3t"},
+		MetricPluginArgs:   map[string]interface{}{"api-key": "s3cr3t"},
+	}
+	handler := NewAdminHandler(options, nil, nil, nil)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/options", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d", rw.Code)
+	}
+
+	body := rw.Body.String()
+	if strings.Contains(body, "s3cr3t") {
+		t.Fatalf("expected plugin args to be redacted, got %s", body)
+	}
+	if !strings.Contains(body, `"upstream_plugin_args":["consul-token"]`) {
+		t.Fatalf("expected plugin arg names, got %s", body)
+	}
+}
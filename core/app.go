@@ -1,6 +1,8 @@
 package core
 
 import (
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jonmorehouse/gatekeeper/gatekeeper"
@@ -8,14 +10,34 @@ import (
 )
 
 type App struct {
+	options         Options
 	servers         ServerContainer
 	plugins         PluginManagerContainer
 	components      []interface{}
 	metricWriter    MetricWriter
 	upstreamManager UpstreamManager
+	certificates    CertificateStore
+	tcpProxy        TCPProxy
+	adminServer     *AdminServer
+	healthServer    *AdminServer
+
+	// ready is true once the app has started and until it begins draining
+	ready    bool
+	draining bool
+
+	// number of upstream plugins which have been given the upstreamManager
+	managedUpstreamPlugins int
+
+	RWMutex
 }
 
 func New(options Options) (*App, error) {
+	// pick up any listeners passed along by a parent process during an
+	// upgrade, before any plugin processes are started
+	if err := inherited.load(); err != nil {
+		return nil, err
+	}
+
 	// build out global metrics based components
 	metricWriter := NewMetricWriter(int(options.MetricBufferSize), options.MetricFlushInterval)
 	metricWriter.EventMetric(&gatekeeper.EventMetric{
@@ -59,22 +81,85 @@ func New(options Options) (*App, error) {
 		modifier = NewPluginModifier(plugins[ModifierPlugin])
 	}
 
-	proxier := NewProxier(modifier, metricWriter)
-	servers := buildServers(options, router, loadBalancer, modifier, proxier, metricWriter)
+	// build out the certificate store for the https servers, which
+	// requires certificates to be configured when either is enabled
+	var certificates CertificateStore
+	if hasTLSListener(options.Listeners) {
+		var err error
+		certificates, err = NewCertificateStore(options.TLSCertificates, options.TLSClientCAFile, options.TLSReloadInterval, metricWriter)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return &App{
-		components: []interface{}{
-			profiler,
-			modifier,
-			router,
-			loadBalancer,
-			upstreamManager,
-		},
+	// build out a transport for each upstream
+	transports := NewTransportManager(broadcaster, options)
+
+	proxier := NewProxier(options.DefaultProxyTimeout, modifier, transports, metricWriter)
+	servers, err := buildServers(options, certificates, router, loadBalancer, modifier, proxier, metricWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	// upstreams with the tcp protocol are proxied at the connection level,
+	// rather than by the servers
+	tcpProxy := NewTCPProxy(broadcaster, options, loadBalancer, metricWriter)
+
+	// components are stopped in this order; the upstreamManager first so
+	// that no new upstreams are published, followed by the components
+	// which depend upon them.
+	components := []interface{}{
+		upstreamManager,
+		router,
+		loadBalancer,
+		modifier,
+		transports,
+		tcpProxy,
+		profiler,
+	}
+	if certificates != nil {
+		components = append(components, certificates)
+	}
+
+	app := &App{
+		options:         options,
+		components:      components,
 		plugins:         plugins,
 		servers:         servers,
 		metricWriter:    metricWriter,
 		upstreamManager: upstreamManager,
-	}, nil
+		certificates:    certificates,
+		tcpProxy:        tcpProxy,
+	}
+
+	// health checks are served alongside the admin api, wherever it is
+	// served, and on their own listener. They aren't served on the
+	// internal servers outside of the AdminPrefix, where they would
+	// shadow the routes of upstreams.
+	health := NewHealthHandler(app.Health)
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/", NewAdminHandler(options, upstreamManager, router, plugins))
+	adminMux.Handle("/healthz", health)
+	adminMux.Handle("/readyz", health)
+
+	if options.AdminOnInternal {
+		filterServers(servers, []gatekeeper.Protocol{gatekeeper.HTTPInternal, gatekeeper.HTTPSInternal}, func(server Server) error {
+			server.Handle(AdminPrefix+"/", http.StripPrefix(AdminPrefix, adminMux))
+			return nil
+		})
+	}
+
+	if options.AdminListener != nil {
+		app.adminServer = NewAdminServer(*options.AdminListener, adminMux)
+		app.components = append(app.components, app.adminServer)
+	}
+
+	if options.HealthListener != nil {
+		app.healthServer = NewAdminServer(*options.HealthListener, health)
+		app.components = append(app.components, app.healthServer)
+	}
+
+	return app, nil
 }
 
 func (a *App) Start() error {
@@ -94,7 +179,8 @@ func (a *App) Start() error {
 			return err
 		}
 
-		// if this is an upstreamPlugin, then the Manager needs to be set accordingly
+		// if this is an upstreamPlugin, then the Manager needs to be set
+		// accordingly, before the plugin is configured and started
 		if UpstreamPlugin == manager.Type() {
 			if err := manager.CallOnce("SetManager", func(plugin Plugin) error {
 				upstreamPlugin, ok := plugin.(upstream_plugin.PluginClient)
@@ -105,6 +191,14 @@ func (a *App) Start() error {
 			}); err != nil {
 				return err
 			}
+
+			a.Lock()
+			a.managedUpstreamPlugins += 1
+			a.Unlock()
+		}
+
+		if err := manager.Start(); err != nil {
+			return err
 		}
 
 		a.metricWriter.AddPlugin(manager)
@@ -119,29 +213,54 @@ func (a *App) Start() error {
 		return err
 	}
 
-	return filterServers(a.servers, nil, func(i Server) error {
+	if err := filterServers(a.servers, nil, func(i Server) error {
 		return i.Start()
-	})
+	}); err != nil {
+		return err
+	}
+
+	// let the parent process know that this process is ready, when this
+	// process was started by an upgrade
+	a.setReady(true)
+	return inherited.finish()
 }
 
+// Stop drains and stops the app within the given duration. Servers are
+// drained first, so that in-flight requests can finish while the routers,
+// load balancers and plugins they depend upon are still running. Those are
+// then stopped in dependency order, with the metric plugins stopped last.
 func (a *App) Stop(duration time.Duration) error {
-	a.eventMetric(gatekeeper.AppStoppedEvent)
+	deadline := time.Now().Add(duration)
 	errs := NewMultiError()
 
+	// fail readiness and wait for in-flight requests to finish
+	a.Lock()
+	a.draining = true
+	a.Unlock()
+	a.setReady(false)
+	a.eventMetric(gatekeeper.AppDrainingEvent)
+	errs.Add(asyncFilterServers(a.servers, nil, func(server Server) error {
+		return server.Drain(untilDeadline(deadline))
+	}))
+
 	// stop servers
+	a.eventMetric(gatekeeper.AppStoppedEvent)
 	errs.Add(filterServers(a.servers, nil, func(server Server) error {
-		return server.Stop(duration)
+		return server.Stop(untilDeadline(deadline))
 	}))
 
-	// stop the plugins, apart from the metricWriter
-	typs := []PluginType{UpstreamPlugin, RouterPlugin, LoadBalancerPlugin, ModifierPlugin}
-	errs.Add(asyncPluginFilter(a.plugins, typs, func(pluginManager PluginManager) error {
-		return pluginManager.Stop()
-	}))
+	// stop the plugins, apart from the metricWriter, one type at a time so
+	// that upstream plugins stop publishing before the plugins consuming
+	// upstreams are stopped
+	for _, typ := range []PluginType{UpstreamPlugin, RouterPlugin, LoadBalancerPlugin, ModifierPlugin} {
+		errs.Add(asyncPluginFilter(a.plugins, []PluginType{typ}, func(pluginManager PluginManager) error {
+			return pluginManager.Stop()
+		}))
+	}
 
 	// stop all other components
 	errs.Add(filterGracefulStoppers(a.components, func(i gracefulStopper) error {
-		return i.Stop(duration)
+		return i.Stop(untilDeadline(deadline))
 	}))
 	errs.Add(filterStoppers(a.components, func(i stopper) error {
 		return i.Stop()
@@ -154,6 +273,98 @@ func (a *App) Stop(duration time.Duration) error {
 	return errs.ToErr()
 }
 
+// Upgrade execs a new gatekeeper process, with the same binary path and
+// arguments, handing it the listening socket of each server and TCP upstream.
+// It returns once the new process has started and is ready to serve, or after
+// the UpgradeTimeout, at which point the caller is expected to Stop this App.
+func (a *App) Upgrade() error {
+	listeners := make([]upgradeListener, 0)
+	filterServers(a.servers, nil, func(server Server) error {
+		listeners = append(listeners, server)
+		return nil
+	})
+	if a.adminServer != nil {
+		listeners = append(listeners, a.adminServer)
+	}
+	if a.healthServer != nil {
+		listeners = append(listeners, a.healthServer)
+	}
+	listeners = append(listeners, a.tcpProxy.UpgradeListeners()...)
+
+	process, err := upgrade(listeners, a.options.UpgradeTimeout)
+	if err != nil {
+		a.metricWriter.EventMetric(&gatekeeper.EventMetric{
+			Timestamp: time.Now(),
+			Event:     gatekeeper.AppUpgradeErrorEvent,
+			Extra: map[string]string{
+				"error": err.Error(),
+			},
+		})
+		return err
+	}
+
+	a.metricWriter.EventMetric(&gatekeeper.EventMetric{
+		Timestamp: time.Now(),
+		Event:     gatekeeper.AppUpgradedEvent,
+		Extra: map[string]string{
+			"pid": strconv.Itoa(process.Pid),
+		},
+	})
+	return nil
+}
+
+// Ready returns true when the app has started and is not draining, each of
+// its plugins has passed its first heartbeat and at least one upstream plugin
+// has been given the upstreamManager.
+func (a *App) Ready() bool {
+	return a.Health().Ready
+}
+
+// Health reports the readiness of the app and each of its plugins
+func (a *App) Health() *HealthReport {
+	a.RLock()
+	ready, draining := a.ready, a.draining
+	managedUpstreamPlugins := a.managedUpstreamPlugins
+	a.RUnlock()
+
+	report := &HealthReport{
+		Ready:      true,
+		Components: make([]*ComponentHealth, 0),
+	}
+
+	appMessage := "starting"
+	if draining {
+		appMessage = "draining"
+	}
+	report.add("app", ready, appMessage)
+	report.add("upstream-manager", managedUpstreamPlugins > 0, "no upstream plugin has been given the upstream manager")
+
+	for _, typ := range allPluginTypes {
+		for _, manager := range a.plugins[typ] {
+			pluginHealth(report, manager)
+		}
+	}
+
+	return report
+}
+
+func (a *App) setReady(ready bool) {
+	a.Lock()
+	defer a.Unlock()
+	a.ready = ready
+}
+
+// Reload reloads any configuration which is able to change without
+// restarting the App; currently the certificates served by the https servers
+// and the CA bundle which client certificates are verified against.
+func (a *App) Reload() error {
+	if a.certificates == nil {
+		return nil
+	}
+
+	return a.certificates.Reload()
+}
+
 func (a *App) eventMetric(event gatekeeper.Event) {
 	a.metricWriter.EventMetric(&gatekeeper.EventMetric{
 		Timestamp: time.Now(),
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+	upstream_plugin "github.com/jonmorehouse/gatekeeper/plugin/upstream"
+)
+
+// testPlugin is an upstream plugin whose SetManager blocks until setManagerCh
+// is closed, when it is non-nil
+type testPlugin struct {
+	setManagerCh chan struct{}
+}
+
+func (p *testPlugin) Start() error                           { return nil }
+func (p *testPlugin) Stop() error                            { return nil }
+func (p *testPlugin) Configure(map[string]interface{}) error { return nil }
+func (p *testPlugin) Heartbeat() error                       { return nil }
+func (p *testPlugin) Kill()                                  {}
+
+func (p *testPlugin) SetManager(upstream_plugin.Manager) error {
+	if p.setManagerCh != nil {
+		<-p.setManagerCh
+	}
+	return nil
+}
+
+func (p *testPlugin) WriteUpstreamMetrics([]*gatekeeper.UpstreamMetric) []error { return nil }
+
+// testPluginManager calls its plugin directly, reporting the heartbeat it is
+// given and recording when it is stopped
+type testPluginManager struct {
+	typ    PluginType
+	plugin Plugin
+
+	heartbeat time.Time
+	onStop    func()
+	sync.Mutex
+}
+
+func (m *testPluginManager) Build() error { return nil }
+func (m *testPluginManager) Start() error { return nil }
+
+func (m *testPluginManager) Stop() error {
+	if m.onStop != nil {
+		m.onStop()
+	}
+	return nil
+}
+
+func (m *testPluginManager) Call(method string, cb func(Plugin) error) error     { return cb(m.plugin) }
+func (m *testPluginManager) CallOnce(method string, cb func(Plugin) error) error { return cb(m.plugin) }
+func (m *testPluginManager) Grab(cb func(Plugin))                                { cb(m.plugin) }
+func (m *testPluginManager) Type() PluginType                                    { return m.typ }
+func (m *testPluginManager) Name() string                                        { return "test" }
+func (m *testPluginManager) Cmd() string                                         { return "test" }
+func (m *testPluginManager) Restarts() uint                                      { return 0 }
+
+func (m *testPluginManager) LastHeartbeat() time.Time {
+	m.Lock()
+	defer m.Unlock()
+	return m.heartbeat
+}
+
+func (m *testPluginManager) setHeartbeat(heartbeat time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.heartbeat = heartbeat
+}
+
+// testServer is a Server which isn't bound to a listener, and whose Drain
+// calls onDrain with the timeout it is given
+type testServer struct {
+	onDrain func(time.Duration) error
+}
+
+func (s *testServer) Start() error             { return nil }
+func (s *testServer) Stop(time.Duration) error { return nil }
+func (s *testServer) InFlight() int64          { return 0 }
+func (s *testServer) ListenerConfig() ListenerConfig {
+	return ListenerConfig{Protocol: gatekeeper.HTTPPublic}
+}
+func (s *testServer) ListenerFile() (*os.File, error) {
+	return nil, ServerNotStartedError
+}
+func (s *testServer) ListenerHandedOff()                {}
+func (s *testServer) Handle(string, http.Handler)       {}
+func (s *testServer) Drain(timeout time.Duration) error { return s.onDrain(timeout) }
+
+func newTestApp(server Server, managers ...*testPluginManager) *App {
+	plugins := make(PluginManagerContainer)
+	for _, manager := range managers {
+		plugins[manager.typ] = append(plugins[manager.typ], manager)
+	}
+
+	return &App{
+		servers:      ServerContainer{gatekeeper.HTTPPublic: {server}},
+		plugins:      plugins,
+		metricWriter: NewMetricWriter(1, time.Hour),
+	}
+}
+
+func TestAppHealth_readyOnceStartedUntilDraining(t *testing.T) {
+	upstreamPlugin := &testPlugin{setManagerCh: make(chan struct{})}
+	manager := &testPluginManager{typ: UpstreamPlugin, plugin: upstreamPlugin}
+
+	var app *App
+	var drainingReport *HealthReport
+	var drainingCode int
+	app = newTestApp(&testServer{onDrain: func(time.Duration) error {
+		drainingReport = app.Health()
+		rw := httptest.NewRecorder()
+		NewHealthHandler(app.Health).ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+		drainingCode = rw.Code
+		return nil
+	}}, manager)
+	health := NewHealthHandler(app.Health)
+
+	readyz := func() int {
+		rw := httptest.NewRecorder()
+		health.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+		return rw.Code
+	}
+	notReady := func() []string {
+		var names []string
+		for _, component := range app.Health().Components {
+			if !component.Ready {
+				names = append(names, component.Name)
+			}
+		}
+		return names
+	}
+
+	// the upstream plugin hasn't been given the upstream manager yet
+	startErrCh := make(chan error, 1)
+	go func() { startErrCh <- app.Start() }()
+	time.Sleep(50 * time.Millisecond)
+	if names := notReady(); len(names) != 3 || readyz() != 503 {
+		t.Fatalf("expected the app, upstream manager and plugin not to be ready, got %v", names)
+	}
+
+	// nor has the plugin passed its first heartbeat
+	close(upstreamPlugin.setManagerCh)
+	if err := <-startErrCh; err != nil {
+		t.Fatal(err)
+	}
+	if names := notReady(); len(names) != 1 || names[0] != "upstream-plugin:test" || readyz() != 503 {
+		t.Fatalf("expected only the plugin not to be ready, got %v", names)
+	}
+
+	manager.setHeartbeat(time.Now())
+	if names := notReady(); len(names) != 0 || readyz() != 200 {
+		t.Fatalf("expected the app to be ready, got %v", names)
+	}
+
+	// readiness fails as soon as the app starts draining, while health
+	// checks keep passing
+	if err := app.Stop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if drainingReport == nil || drainingReport.Ready || drainingCode != 503 {
+		t.Fatalf("expected the app not to be ready while draining, got %+v %d", drainingReport, drainingCode)
+	}
+	if drainingReport.Components[0].Name != "app" || drainingReport.Components[0].Message != "draining" {
+		t.Fatalf("expected the app to report it is draining, got %+v", drainingReport.Components[0])
+	}
+
+	rw := httptest.NewRecorder()
+	health.ServeHTTP(rw, httptest.NewRequest("GET", "/healthz", nil))
+	if rw.Code != 200 {
+		t.Fatalf("expected /healthz to pass, got %d", rw.Code)
+	}
+}
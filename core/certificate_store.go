@@ -0,0 +1,217 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// CertificateStore holds the certificates served by the https servers and
+// exposes them through a tls.Config.GetCertificate compatible method, along
+// with the CA bundle which client certificates are verified against. This
+// allows certificates to be reloaded from disk without restarting listeners.
+type CertificateStore interface {
+	starter
+	stopper
+
+	// Reload reloads every certificate and the client CA bundle from
+	// disk, keeping the current ones in place if any of them fail to load.
+	Reload() error
+
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// ClientCAs returns the client CA bundle, or nil when there isn't one
+	ClientCAs() *x509.CertPool
+}
+
+// NewCertificateStore loads the given certificates and the optional client CA
+// bundle, returning an error if any of them are invalid. When interval is
+// non-zero, the files are checked for changes on that interval and reloaded
+// when they are modified.
+func NewCertificateStore(pairs []TLSCertificate, clientCAFile string, interval time.Duration, metricWriter MetricWriterClient) (CertificateStore, error) {
+	if len(pairs) == 0 {
+		return nil, TLSCertificateRequiredError
+	}
+
+	store := &certificateStore{
+		pairs:        pairs,
+		clientCAFile: clientCAFile,
+		interval:     interval,
+		metricWriter: metricWriter,
+		HookManager:  NewHookManager(),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+type certificateStore struct {
+	pairs        []TLSCertificate
+	clientCAFile string
+	interval     time.Duration
+	metricWriter MetricWriterClient
+
+	certificates []*tls.Certificate
+	names        map[string]*tls.Certificate
+	clientCAs    *x509.CertPool
+	modTimes     map[string]time.Time
+
+	RWMutex
+	HookManager
+}
+
+func (c *certificateStore) Start() error {
+	if c.interval > 0 {
+		c.AddHook(c.interval, c.reloadIfModified)
+	}
+	return nil
+}
+
+func (c *certificateStore) Reload() error {
+	if err := c.load(); err != nil {
+		c.eventMetric(gatekeeper.CertificateReloadErrorEvent, map[string]string{
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	c.RLock()
+	names := make([]string, 0, len(c.names))
+	for name, _ := range c.names {
+		names = append(names, name)
+	}
+	c.RUnlock()
+
+	c.eventMetric(gatekeeper.CertificateReloadedEvent, map[string]string{
+		"names": strings.Join(names, ","),
+	})
+	return nil
+}
+
+// GetCertificate selects a certificate for the SNI server name in the client
+// hello, preferring an exact match, then a wildcard match and finally falling
+// back to the first configured certificate.
+func (c *certificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if certificate, ok := c.names[name]; ok {
+		return certificate, nil
+	}
+
+	labels := strings.Split(name, ".")
+	if len(labels) > 1 {
+		labels[0] = "*"
+		if certificate, ok := c.names[strings.Join(labels, ".")]; ok {
+			return certificate, nil
+		}
+	}
+
+	return c.certificates[0], nil
+}
+
+func (c *certificateStore) ClientCAs() *x509.CertPool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.clientCAs
+}
+
+// load reads and parses every certificate and the client CA bundle from disk,
+// only swapping them into place once they have all loaded successfully.
+func (c *certificateStore) load() error {
+	certificates := make([]*tls.Certificate, 0, len(c.pairs))
+	names := make(map[string]*tls.Certificate)
+	modTimes := make(map[string]time.Time)
+
+	for _, pair := range c.pairs {
+		for _, path := range []string{pair.CertFile, pair.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			modTimes[path] = info.ModTime()
+		}
+
+		certificate, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return err
+		}
+
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return err
+		}
+		certificate.Leaf = leaf
+		certificates = append(certificates, &certificate)
+
+		// the first certificate to claim a name wins, which matches the
+		// order certificates were configured in
+		for _, name := range append([]string{leaf.Subject.CommonName}, leaf.DNSNames...) {
+			name = strings.ToLower(name)
+			if _, ok := names[name]; name != "" && !ok {
+				names[name] = &certificate
+			}
+		}
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		info, err := os.Stat(c.clientCAFile)
+		if err != nil {
+			return err
+		}
+		modTimes[c.clientCAFile] = info.ModTime()
+
+		clientCAs, err = loadClientCAs(c.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.certificates = certificates
+	c.names = names
+	c.clientCAs = clientCAs
+	c.modTimes = modTimes
+	return nil
+}
+
+// reloadIfModified is called periodically by the HookManager and reloads the
+// certificates when any of the certificate, key or client CA files have
+// changed on disk.
+func (c *certificateStore) reloadIfModified() error {
+	c.RLock()
+	modified := false
+	for path, modTime := range c.modTimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			modified = true
+			break
+		}
+	}
+	c.RUnlock()
+
+	if !modified {
+		return nil
+	}
+
+	return c.Reload()
+}
+
+func (c *certificateStore) eventMetric(event gatekeeper.Event, extra map[string]string) {
+	extra["process"] = "certificate-store"
+	c.metricWriter.EventMetric(&gatekeeper.EventMetric{
+		Timestamp: time.Now(),
+		Event:     event,
+		Extra:     extra,
+	})
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCertificateStoreGetCertificate_selectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	defaultCertFile, defaultKeyFile := newTestCertificate(t, nil, "default.example.com").writeFiles(t, dir, "default")
+	apiCertFile, apiKeyFile := newTestCertificate(t, nil, "api.example.com", "api.example.com", "api.example.org").writeFiles(t, dir, "api")
+	wildcardCertFile, wildcardKeyFile := newTestCertificate(t, nil, "*.example.com").writeFiles(t, dir, "wildcard")
+
+	store, err := NewCertificateStore([]TLSCertificate{
+		{defaultCertFile, defaultKeyFile},
+		{apiCertFile, apiKeyFile},
+		{wildcardCertFile, wildcardKeyFile},
+	}, "", 0, NewMetricWriter(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		serverName string
+		commonName string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"API.example.org.", "api.example.com"},
+		{"www.example.com", "*.example.com"},
+		{"eu.www.example.com", "default.example.com"},
+		{"example.net", "default.example.com"},
+		{"", "default.example.com"},
+	}
+
+	for _, testCase := range testCases {
+		certificate, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: testCase.serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certificate.Leaf.Subject.CommonName != testCase.commonName {
+			t.Fatalf("%q: expected %s, got %s", testCase.serverName, testCase.commonName, certificate.Leaf.Subject.CommonName)
+		}
+	}
+}
+
+func TestCertificateStoreReload_swapsCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCertificate(t, nil, "example.com").writeFiles(t, dir, "server")
+
+	store, err := NewCertificateStore([]TLSCertificate{{certFile, keyFile}}, "", 0, NewMetricWriter(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commonName := func() string {
+		certificate, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		return certificate.Leaf.Subject.CommonName
+	}
+
+	// nothing is reloaded until the files change on disk
+	if err := store.(*certificateStore).reloadIfModified(); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestCertificate(t, nil, "rotated.example.com").writeFiles(t, dir, "server")
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(*certificateStore).reloadIfModified(); err != nil {
+		t.Fatal(err)
+	}
+	if commonName() != "rotated.example.com" {
+		t.Fatalf("expected the rotated certificate, got %s", commonName())
+	}
+
+	// a pair which fails to load keeps the current certificates in place
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("expected an invalid key to fail to reload")
+	}
+	if commonName() != "rotated.example.com" {
+		t.Fatalf("expected the previous certificate to be kept, got %s", commonName())
+	}
+
+	if err := os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(*certificateStore).reloadIfModified(); err == nil {
+		t.Fatal("expected a missing certificate to fail to reload")
+	}
+	if commonName() != "rotated.example.com" {
+		t.Fatalf("expected the previous certificate to be kept, got %s", commonName())
+	}
+}
+
+func TestCertificateStoreReload_reloadsClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, nil, "client-ca")
+	client := newTestCertificate(t, ca, "client")
+	certFile, keyFile := newTestCertificate(t, nil, "example.com", "example.com").writeFiles(t, dir, "server")
+	caFile, _ := ca.writeFiles(t, dir, "client-ca")
+
+	store, err := NewCertificateStore([]TLSCertificate{{certFile, keyFile}}, caFile, 0, NewMetricWriter(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.cert.Verify(x509.VerifyOptions{Roots: store.ClientCAs(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Fatalf("expected the client certificate to verify, got %v", err)
+	}
+
+	// the bundle is replaced on reload, and kept when it fails to load
+	rotated := newTestCertificate(t, nil, "rotated-ca")
+	rotated.writeFiles(t, dir, "client-ca")
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.cert.Verify(x509.VerifyOptions{Roots: store.ClientCAs(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+		t.Fatal("expected the client certificate to no longer verify")
+	}
+
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pool := store.ClientCAs()
+	if err := store.Reload(); err == nil {
+		t.Fatal("expected an invalid bundle to fail to reload")
+	}
+	if !store.ClientCAs().Equal(pool) {
+		t.Fatal("expected the previous bundle to be kept")
+	}
+}
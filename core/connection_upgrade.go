@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// isUpgradeRequest returns true when the request asks to switch protocols,
+// such as to a websocket, with the `Connection: Upgrade` and `Upgrade` headers
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ProxyUpgrade proxies a request which asks to switch protocols. The upgrade
+// handshake is sent to the backend and, once the backend switches protocols,
+// the client connection is hijacked and spliced to the backend connection
+// until either side closes it. Responses which do not switch protocols are
+// written back to the client as is.
+func (p *proxier) ProxyUpgrade(rw http.ResponseWriter,
+	httpReq *http.Request,
+	req *gatekeeper.Request,
+	upstream *gatekeeper.Upstream,
+	backend *gatekeeper.Backend,
+	metric *gatekeeper.RequestMetric) error {
+
+	backendAddress, err := url.Parse(backend.Address)
+	if err != nil {
+		return BackendAddressError
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		return UpgradeNotSupportedError
+	}
+
+	timeout := upstream.Timeout
+	if timeout == time.Millisecond*0 {
+		timeout = p.defaultTimeout
+	}
+
+	p.modifyProxyRequest(httpReq, req)
+	if upstream.ProxyProtocol != 0 {
+		httpReq = httpReq.WithContext(withProxyProtocolAddrs(httpReq))
+	}
+	outReq := p.upgradeRequest(httpReq, backendAddress)
+
+	// the timeout only applies to the handshake; the context must outlive
+	// the round trip, as canceling it would close the upgraded connection
+	trace := newRequestTrace()
+	ctx, cancel := context.WithCancel(httptrace.WithClientTrace(httpReq.Context(), trace.ClientTrace()))
+	defer cancel()
+	timer := time.AfterFunc(timeout, cancel)
+
+	startTS := time.Now()
+	resp, err := p.transports.Transport(upstream).RoundTrip(outReq.WithContext(ctx))
+	timedOut := !timer.Stop()
+	metric.ProxyLatency = time.Now().Sub(startTS)
+	trace.writeMetric(metric)
+
+	if err != nil {
+		if timedOut {
+			err = ProxyTimeoutError
+		} else if httpReq.Context().Err() != nil {
+			err = ClientCanceledError
+		}
+		p.writeProxyError(rw, req, trace.proxyError(err), metric)
+		return nil
+	}
+
+	metric.Response = gatekeeper.NewResponse(resp)
+	metric.BackendProto = resp.Proto
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		for header, values := range resp.Header {
+			for _, value := range values {
+				rw.Header().Add(header, value)
+			}
+		}
+		rw.WriteHeader(resp.StatusCode)
+		io.Copy(rw, resp.Body)
+		return nil
+	}
+
+	backendConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return UpgradeNotSupportedError
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		backendConn.Close()
+		return err
+	}
+
+	// complete the handshake with the client, and pass along anything the
+	// client sent after its request that has already been buffered
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		backendConn.Close()
+		return nil
+	}
+
+	var buffered int64
+	if clientBuf.Reader.Buffered() > 0 {
+		buffered, _ = io.CopyN(backendConn, clientBuf.Reader, int64(clientBuf.Reader.Buffered()))
+	}
+
+	upgradeStartTS := time.Now()
+	fromClient, fromBackend, err := splice(upgradedConn{clientConn}, backendConn)
+
+	metric.Upgraded = true
+	metric.UpgradeDuration = time.Now().Sub(upgradeStartTS)
+	metric.BytesFromClient = buffered + fromClient
+	metric.BytesFromBackend = fromBackend
+	metric.Error = gatekeeper.NewError(err)
+	return nil
+}
+
+// upgradedConn hides the client connection's CloseWrite from splice. The
+// backend's side of an upgraded connection can't be half-closed, so the client's
+// isn't either; the client connection is closed as soon as the backend hangs
+// up, rather than being left open until the client does.
+type upgradedConn struct {
+	io.ReadWriteCloser
+}
+
+// upgradeRequest builds the request sent to the backend, which unlike other
+// proxied requests keeps the Connection and Upgrade headers.
+func (p *proxier) upgradeRequest(httpReq *http.Request, backendAddress *url.URL) *http.Request {
+	outReq := httpReq.Clone(httpReq.Context())
+	outReq.RequestURI = ""
+	outReq.URL.Scheme = backendAddress.Scheme
+	outReq.URL.Host = backendAddress.Host
+	outReq.URL.Path = singleJoiningSlash(backendAddress.Path, outReq.URL.Path)
+	outReq.Header.Set("Connection", "Upgrade")
+
+	// match the X-Forwarded-For handling of httputil.ReverseProxy
+	if clientIP, _, err := net.SplitHostPort(httpReq.RemoteAddr); err == nil {
+		if prior, ok := outReq.Header["X-Forwarded-For"]; ok {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	return outReq
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
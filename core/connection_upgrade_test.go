@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// newUpgradeBackend switches protocols on every request apart from those to
+// `/refuse`, after which it echoes the connection on `/echo` and says `bye`
+// and hangs up on `/hangup`. Each upgraded connection is sent on closedCh
+// once the backend has read to its end.
+func newUpgradeBackend(t *testing.T, closedCh chan struct{}) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/refuse" || req.Header.Get("Upgrade") != "echo" {
+			rw.Header().Set("X-Refused", "true")
+			rw.WriteHeader(http.StatusForbidden)
+			io.WriteString(rw, "no upgrade")
+			return
+		}
+
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+		switch req.URL.Path {
+		case "/echo":
+			io.Copy(conn, buf)
+		case "/hangup":
+			io.WriteString(conn, "bye")
+			return
+		}
+		closedCh <- struct{}{}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// newUpgradeProxy proxies upgrades to the backend, sending each request's
+// metric on metricCh once ProxyUpgrade returns
+func newUpgradeProxy(t *testing.T, backendURL string, metricCh chan *gatekeeper.RequestMetric) *httptest.Server {
+	proxier := NewProxier(time.Second, NewLocalModifier(), NewTransportManager(NewBroadcaster(), Options{}), NewMetricWriter(10, time.Second))
+	upstream := &gatekeeper.Upstream{ID: "echo"}
+	backend := &gatekeeper.Backend{ID: "echo-1", Address: backendURL}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, httpReq *http.Request) {
+		metric := &gatekeeper.RequestMetric{}
+		if err := proxier.ProxyUpgrade(rw, httpReq, gatekeeper.NewRequest(httpReq, gatekeeper.HTTPPublic), upstream, backend, metric); err != nil {
+			t.Error(err)
+		}
+		metricCh <- metric
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// dialUpgrade sends an upgrade request for the path, returning the connection
+// and the response
+func dialUpgrade(t *testing.T, proxy *httptest.Server, path string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+func receiveMetric(t *testing.T, metricCh chan *gatekeeper.RequestMetric) *gatekeeper.RequestMetric {
+	select {
+	case metric := <-metricCh:
+		return metric
+	case <-time.After(time.Second):
+		t.Fatal("expected ProxyUpgrade to return")
+		return nil
+	}
+}
+
+func TestProxierProxyUpgrade_splicesConnections(t *testing.T) {
+	closedCh := make(chan struct{}, 1)
+	metricCh := make(chan *gatekeeper.RequestMetric, 1)
+	proxy := newUpgradeProxy(t, newUpgradeBackend(t, closedCh).URL, metricCh)
+
+	conn, reader, resp := dialUpgrade(t, proxy, "/echo")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("expected the backend to switch protocols, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	for _, message := range []string{"ping", "hello, backend"} {
+		io.WriteString(conn, message)
+		echoed := make([]byte, len(message))
+		if _, err := io.ReadFull(reader, echoed); err != nil || string(echoed) != message {
+			t.Fatalf("expected %q to be echoed, got %q %v", message, echoed, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// the client hanging up closes the backend's connection, and then the
+	// client's
+	conn.(*net.TCPConn).CloseWrite()
+	select {
+	case <-closedCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the backend connection to be closed")
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) != 0 {
+		t.Fatalf("expected the client connection to be closed, got %q %v", rest, err)
+	}
+
+	metric := receiveMetric(t, metricCh)
+	if !metric.Upgraded || metric.BytesFromClient != 18 || metric.BytesFromBackend != 18 {
+		t.Fatalf("expected 18 bytes each way, got %t %d %d", metric.Upgraded, metric.BytesFromClient, metric.BytesFromBackend)
+	}
+	if metric.UpgradeDuration < 50*time.Millisecond || metric.Response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected the upgrade to last the connection, got %s %d", metric.UpgradeDuration, metric.Response.StatusCode)
+	}
+}
+
+func TestProxierProxyUpgrade_closesClientWhenBackendHangsUp(t *testing.T) {
+	metricCh := make(chan *gatekeeper.RequestMetric, 1)
+	proxy := newUpgradeProxy(t, newUpgradeBackend(t, nil).URL, metricCh)
+
+	_, reader, resp := dialUpgrade(t, proxy, "/hangup")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected the backend to switch protocols, got %d", resp.StatusCode)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || string(rest) != "bye" {
+		t.Fatalf("expected the client connection to be closed after bye, got %q %v", rest, err)
+	}
+
+	metric := receiveMetric(t, metricCh)
+	if !metric.Upgraded || metric.BytesFromClient != 0 || metric.BytesFromBackend != 3 {
+		t.Fatalf("expected 3 bytes from the backend, got %t %d %d", metric.Upgraded, metric.BytesFromClient, metric.BytesFromBackend)
+	}
+}
+
+func TestProxierProxyUpgrade_passesThroughRefusedUpgrades(t *testing.T) {
+	metricCh := make(chan *gatekeeper.RequestMetric, 1)
+	proxy := newUpgradeProxy(t, newUpgradeBackend(t, nil).URL, metricCh)
+
+	_, _, resp := dialUpgrade(t, proxy, "/refuse")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("X-Refused") != "true" || string(body) != "no upgrade" {
+		t.Fatalf("expected the backend's response, got %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+
+	if metric := receiveMetric(t, metricCh); metric.Upgraded || metric.Response.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the request not to be upgraded, got %t %d", metric.Upgraded, metric.Response.StatusCode)
+	}
+}
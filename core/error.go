@@ -18,6 +18,7 @@ var (
 	InvalidEventError          = errors.New("invalid event error")
 	UnsubscribedEventError     = errors.New("unsubscribed event error")
 	RouteNotFoundError         = errors.New("No route found error")
+	RouteNotExposedError       = errors.New("route not exposed on this protocol")
 	InvalidUpstreamEventErr    = errors.New("invalid upstream event")
 
 	// Configuration error
@@ -25,8 +26,13 @@ var (
 
 	// Specific errors
 	ServerShuttingDownError = errors.New("server shutting down")
+	DrainTimeoutError       = errors.New("timed out draining in-flight requests")
+	ServerNotStartedError   = errors.New("server not started")
 	ResponseWriteError      = errors.New("response write error")
 
+	UpgradeFailedError  = errors.New("upgraded process exited before becoming ready")
+	UpgradeTimeoutError = errors.New("timed out waiting for upgraded process")
+
 	UpstreamNotFoundError    = errors.New("upstream not found")
 	UpstreamDuplicateIDError = errors.New("duplicate upstream ID error")
 
@@ -36,16 +42,34 @@ var (
 	NoBackendsFoundError    = errors.New("no upstream backends found")
 	OrphanedBackendError    = errors.New("orphaned backend error")
 
-	InternalProxierError    = errors.New("internal proxier error")
-	LoadBalancerPluginError = errors.New("load balancer plugin error")
-	ModifierPluginError     = errors.New("modifier plugin error")
-	ProxyTimeoutError       = errors.New("proxy timeout error")
+	TCPUpstreamPortRequiredError = errors.New("tcp upstream port required")
+
+	InternalProxierError     = errors.New("internal proxier error")
+	LoadBalancerPluginError  = errors.New("load balancer plugin error")
+	ModifierPluginError      = errors.New("modifier plugin error")
+	ProxyTimeoutError        = errors.New("proxy timeout error")
+	ClientCanceledError      = errors.New("client canceled request")
+	UpgradeNotSupportedError = errors.New("connection upgrade not supported")
+	GRPCBackendProtocolError = errors.New("grpc requires an h2 or h2c backend protocol")
+
+	ClientCertificateRequiredError = errors.New("client certificate required")
+	InvalidClientCertificateError  = errors.New("invalid client certificate")
+
+	BackendTLSHandshakeError = errors.New("backend tls handshake error")
+	InvalidBackendTLSCAError = errors.New("no certificates found in backend tls ca file")
+
+	InvalidProxyProtocolHeaderError  = errors.New("invalid PROXY protocol header")
+	InvalidProxyProtocolVersionError = errors.New("invalid PROXY protocol version")
 
 	InvalidEventErr      = errors.New("invalid event error")
 	InvalidPluginErr     = errors.New("invalid plugin type error")
 	DuplicateUpstreamErr = errors.New("duplicate upstream error")
 	DuplicateBackendErr  = errors.New("duplicate backend error")
 	BackendAddressErr    = errors.New("invalid backend error")
+
+	RouteConflictErr      = errors.New("route claimed by another upstream")
+	UpstreamNotInSplitErr = errors.New("upstream not in a traffic split")
+	InvalidBackendTLSErr  = errors.New("invalid backend tls")
 )
 
 // goroutine safe error implementing type for managing multiple errors
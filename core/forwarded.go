@@ -0,0 +1,164 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// forwardingHeaders describe the proxies which a request passed through before
+// reaching gatekeeper, and are only trusted from trusted proxies
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Host",
+}
+
+// resolveClientIP returns the address of the client which made the request.
+// Requests from trusted proxies are attributed to the nearest address in their
+// forwarding headers which isn't itself a trusted proxy, preferring the
+// `Forwarded` header over `X-Forwarded-For`. Requests from anywhere else are
+// attributed to the connection's remote address.
+func resolveClientIP(remoteAddr string, header http.Header, trustedProxies []*net.IPNet) string {
+	clientIP := addressIP(remoteAddr)
+	if !isTrustedProxy(clientIP, trustedProxies) {
+		return clientIP
+	}
+
+	chain := forwardedFor(header.Values("Forwarded"))
+	if len(chain) == 0 {
+		chain = splitHeaderList(header.Values("X-Forwarded-For"))
+	}
+
+	// walk back from the nearest proxy, stopping at the first address
+	// which isn't a trusted proxy; obfuscated or unknown addresses can't
+	// be resolved, so the proxy which forwarded them is used instead
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		ip := addressIP(chain[idx])
+		if ip == "" {
+			break
+		}
+
+		clientIP = ip
+		if !isTrustedProxy(ip, trustedProxies) {
+			break
+		}
+	}
+
+	return clientIP
+}
+
+// setForwardedHeaders sets the forwarding headers for the request's backend.
+// Those sent by trusted proxies are added to, and those sent by anyone else are
+// replaced, so that clients can't spoof their address or protocol. The
+// connection's own address is appended to `X-Forwarded-For` when the request
+// is proxied, as httputil.ReverseProxy does.
+func setForwardedHeaders(header http.Header, remoteAddr, host string, protocol gatekeeper.Protocol, trustedProxies []*net.IPNet) {
+	peerIP := addressIP(remoteAddr)
+	if !isTrustedProxy(peerIP, trustedProxies) {
+		for _, name := range forwardingHeaders {
+			header.Del(name)
+		}
+	}
+
+	proto := "http"
+	if protocol.IsTLS() {
+		proto = "https"
+	}
+
+	if header.Get("X-Forwarded-Proto") == "" {
+		header.Set("X-Forwarded-Proto", proto)
+	}
+	if header.Get("X-Forwarded-Host") == "" {
+		header.Set("X-Forwarded-Host", host)
+	}
+
+	element := "proto=" + proto + ";host=" + quoteForwardedValue(host)
+	if peerIP != "" {
+		element = "for=" + quoteForwardedValue(forwardedNode(peerIP)) + ";" + element
+	}
+	if prior := header.Values("Forwarded"); len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	header.Set("Forwarded", element)
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, cidr := range trustedProxies {
+		if cidr.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressIP returns the IP of an address with an optional port, such as
+// `10.0.0.1`, `10.0.0.1:8000`, `[::1]:8000` or `"[::1]"`, or an empty string
+// when it isn't one
+func addressIP(address string) string {
+	address = strings.Trim(strings.TrimSpace(address), `"`)
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedFor returns the `for` parameter of each element of the `Forwarded`
+// headers, from https://tools.ietf.org/html/rfc7239
+func forwardedFor(values []string) []string {
+	var nodes []string
+	for _, element := range splitHeaderList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				nodes = append(nodes, value)
+			}
+		}
+	}
+	return nodes
+}
+
+// forwardedNode formats an IP as a `Forwarded` node, with IPv6 addresses in
+// brackets
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// quoteForwardedValue quotes `Forwarded` parameter values which aren't valid
+// tokens, such as IPv6 nodes and hosts with ports
+func quoteForwardedValue(value string) string {
+	if strings.ContainsAny(value, `:[]" `) {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+// splitHeaderList splits comma-delimited header values into their elements
+func splitHeaderList(values []string) []string {
+	var elements []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestResolveClientIP_walksTrustedProxies(t *testing.T) {
+	trustedProxies, err := ParseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		remoteAddr string
+		header     http.Header
+		clientIP   string
+	}{
+		// untrusted peers can't claim another address
+		{"203.0.113.7:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"203.0.113.7:5000", http.Header{"Forwarded": {"for=198.51.100.1"}}, "203.0.113.7"},
+
+		// the chain is walked back to the first untrusted address
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2"}}, "203.0.113.7"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1", "10.0.0.3, 10.0.0.2"}}, "198.51.100.1"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"10.0.0.1:5000", http.Header{}, "10.0.0.1"},
+
+		// Forwarded is preferred, and its IPv6 nodes are quoted
+		{"10.0.0.1:5000", http.Header{"Forwarded": {`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`}, "X-Forwarded-For": {"198.51.100.1"}}, "2001:db8::1"},
+		{"[fd00::1]:5000", http.Header{"Forwarded": {`for="[2001:db8::1]", for="[fd00::2]"`}}, "2001:db8::1"},
+
+		// unknown and obfuscated nodes stop the walk at the proxy which
+		// forwarded them
+		{"10.0.0.1:5000", http.Header{"Forwarded": {"for=unknown, for=10.0.0.2"}}, "10.0.0.2"},
+		{"10.0.0.1:5000", http.Header{"Forwarded": {"for=_hidden"}}, "10.0.0.1"},
+	}
+
+	for idx, testCase := range testCases {
+		if clientIP := resolveClientIP(testCase.remoteAddr, testCase.header, trustedProxies); clientIP != testCase.clientIP {
+			t.Fatalf("case %d: expected %s, got %s", idx, testCase.clientIP, clientIP)
+		}
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	trustedProxies, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spoofed := http.Header{
+		"Forwarded":         {"for=198.51.100.1;proto=https"},
+		"X-Forwarded-For":   {"198.51.100.1"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"spoofed.example.com"},
+	}
+
+	testCases := []struct {
+		remoteAddr string
+		protocol   gatekeeper.Protocol
+		header     http.Header
+		expected   http.Header
+	}{
+		// headers from untrusted peers are replaced, with the protocol
+		// following the listener's
+		{"203.0.113.7:5000", gatekeeper.HTTPPublic, spoofed, http.Header{
+			"Forwarded":         {"for=203.0.113.7;proto=http;host=example.com"},
+			"X-Forwarded-Proto": {"http"},
+			"X-Forwarded-Host":  {"example.com"},
+		}},
+		{"[2001:db8::1]:5000", gatekeeper.HTTPSPublic, http.Header{}, http.Header{
+			"Forwarded":         {`for="[2001:db8::1]";proto=https;host=example.com`},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"example.com"},
+		}},
+
+		// headers from trusted proxies are kept and added to
+		{"10.0.0.1:5000", gatekeeper.HTTPInternal, spoofed, http.Header{
+			"Forwarded":         {"for=198.51.100.1;proto=https, for=10.0.0.1;proto=http;host=example.com"},
+			"X-Forwarded-For":   {"198.51.100.1"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"spoofed.example.com"},
+		}},
+	}
+
+	for idx, testCase := range testCases {
+		header := testCase.header.Clone()
+		setForwardedHeaders(header, testCase.remoteAddr, "example.com", testCase.protocol, trustedProxies)
+
+		for _, name := range forwardingHeaders {
+			if header.Get(name) != testCase.expected.Get(name) {
+				t.Fatalf("case %d: expected %s to be %q, got %q", idx, name, testCase.expected.Get(name), header.Get(name))
+			}
+		}
+	}
+}
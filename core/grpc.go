@@ -0,0 +1,117 @@
+package core
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// gRPC status codes, from https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcOK               = 0
+	grpcCanceled         = 1
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// errors raised by gatekeeper itself, mapped to the gRPC status which best
+// describes them to a gRPC client
+var grpcErrorStatuses = map[error]int{
+	RouteNotFoundError:      grpcUnimplemented,
+	RouteNotExposedError:    grpcUnimplemented,
+	ServerShuttingDownError: grpcUnavailable,
+	BackendNotFoundError:    grpcUnavailable,
+	NoBackendsFoundError:    grpcUnavailable,
+	ProxyTimeoutError:       grpcDeadlineExceeded,
+	ClientCanceledError:     grpcCanceled,
+
+	// gRPC responses carry their status in trailers, which are lost over
+	// HTTP/1.1, so gRPC requests are only proxied to HTTP/2 backends
+	GRPCBackendProtocolError: grpcUnimplemented,
+
+	ClientCertificateRequiredError: grpcUnauthenticated,
+	InvalidClientCertificateError:  grpcUnauthenticated,
+}
+
+// isGRPCRequest returns true for requests with an `application/grpc` content
+// type, including variants such as `application/grpc+proto`
+func isGRPCRequest(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatusFromHTTP maps an HTTP status code to a gRPC status, following
+// https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func grpcStatusFromHTTP(statusCode int) int {
+	switch statusCode {
+	case 400:
+		return grpcInternal
+	case 401:
+		return grpcUnauthenticated
+	case 403:
+		return grpcPermissionDenied
+	case 404:
+		return grpcUnimplemented
+	case 429, 502, 503, 504:
+		return grpcUnavailable
+	}
+
+	return grpcUnknown
+}
+
+// grpcErrorResponse converts an error response into a gRPC "Trailers-Only"
+// response; a 200 with the grpc-status and grpc-message in the headers and no
+// body, which gRPC clients are able to parse.
+func grpcErrorResponse(err error, resp *gatekeeper.Response) *gatekeeper.Response {
+	status, ok := grpcErrorStatuses[err]
+	if !ok {
+		status = grpcStatusFromHTTP(resp.StatusCode)
+	}
+
+	message := http.StatusText(resp.StatusCode)
+	if err != nil {
+		message = err.Error()
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(status))
+	header.Set("Grpc-Message", grpcEncodeMessage(message))
+
+	return &gatekeeper.Response{
+		StatusCode: 200,
+		Header:     header,
+		Error:      resp.Error,
+	}
+}
+
+// grpcEncodeMessage percent-encodes a grpc-message value, as required by the
+// gRPC over HTTP/2 spec
+func grpcEncodeMessage(message string) string {
+	return strings.Replace(url.PathEscape(message), "%20", " ", -1)
+}
+
+// recordGRPCStatus records the grpc-status of a response on the metric. The
+// status is sent as a trailer, or as a header for "Trailers-Only" responses;
+// when neither is present the status is recorded as unknown.
+func recordGRPCStatus(metric *gatekeeper.RequestMetric, header, trailer http.Header) {
+	value := trailer.Get("Grpc-Status")
+	if value == "" {
+		value = header.Get("Grpc-Status")
+	}
+
+	status, err := strconv.Atoi(value)
+	if err != nil {
+		status = grpcUnknown
+	}
+
+	metric.GRPC = true
+	metric.GRPCStatus = status
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestGRPCErrorResponse_mapsStatuses(t *testing.T) {
+	testCases := []struct {
+		err        error
+		statusCode int
+		grpcStatus string
+	}{
+		{RouteNotFoundError, 404, "12"},
+		{ProxyTimeoutError, 504, "4"},
+		{ClientCanceledError, 499, "1"},
+		{NoBackendsFoundError, 502, "14"},
+		{InvalidClientCertificateError, 403, "16"},
+		{GRPCBackendProtocolError, 502, "12"},
+
+		// anything else is mapped by its HTTP status
+		{errors.New("bad request"), 400, "13"},
+		{errors.New("forbidden"), 403, "7"},
+		{errors.New("rate limited"), 429, "14"},
+		{errors.New("bad gateway"), 502, "14"},
+		{errors.New("teapot"), 418, "2"},
+	}
+
+	for idx, testCase := range testCases {
+		resp := grpcErrorResponse(testCase.err, gatekeeper.NewErrorResponse(testCase.statusCode, testCase.err))
+		if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/grpc" {
+			t.Fatalf("case %d: expected a trailers-only response, got %d %v", idx, resp.StatusCode, resp.Header)
+		}
+		if status := resp.Header.Get("Grpc-Status"); status != testCase.grpcStatus {
+			t.Fatalf("case %d: expected grpc-status %s, got %s", idx, testCase.grpcStatus, status)
+		}
+	}
+
+	err := errors.New("100% broken: ü")
+	resp := grpcErrorResponse(err, gatekeeper.NewErrorResponse(500, err))
+	if message := resp.Header.Get("Grpc-Message"); message != "100%25 broken: %C3%BC" {
+		t.Fatalf("expected a percent-encoded grpc-message, got %q", message)
+	}
+}
+
+func TestRecordGRPCStatus(t *testing.T) {
+	testCases := []struct {
+		header  http.Header
+		trailer http.Header
+		status  int
+	}{
+		{http.Header{}, http.Header{"Grpc-Status": {"0"}}, grpcOK},
+		{http.Header{"Grpc-Status": {"5"}}, http.Header{}, 5},
+		{http.Header{"Grpc-Status": {"5"}}, http.Header{"Grpc-Status": {"14"}}, grpcUnavailable},
+		{http.Header{}, nil, grpcUnknown},
+		{http.Header{"Grpc-Status": {"ok"}}, nil, grpcUnknown},
+	}
+
+	for idx, testCase := range testCases {
+		metric := &gatekeeper.RequestMetric{}
+		recordGRPCStatus(metric, testCase.header, testCase.trailer)
+		if !metric.GRPC || metric.GRPCStatus != testCase.status {
+			t.Fatalf("case %d: expected grpc-status %d, got %d", idx, testCase.status, metric.GRPCStatus)
+		}
+	}
+}
+
+func TestProxierProxy_rejectsGRPCToHTTP1Backends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Fatal("expected the request not to be proxied")
+	}))
+	defer backend.Close()
+
+	proxier := NewProxier(time.Second, NewLocalModifier(), NewTransportManager(NewBroadcaster(), Options{}), NewMetricWriter(10, time.Second))
+
+	httpReq := httptest.NewRequest("POST", "/billing.Invoices/Get", nil)
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	req := gatekeeper.NewRequest(httpReq, gatekeeper.HTTPPublic)
+	metric := &gatekeeper.RequestMetric{}
+
+	rw := httptest.NewRecorder()
+	upstream := &gatekeeper.Upstream{ID: "billing"}
+	if err := proxier.Proxy(rw, httpReq, req, upstream, &gatekeeper.Backend{Address: backend.URL}, metric); err != nil {
+		t.Fatal(err)
+	}
+
+	if rw.Code != 200 || rw.Header().Get("Grpc-Status") != "12" {
+		t.Fatalf("expected grpc-status 12, got %d %v", rw.Code, rw.Header())
+	}
+	if !metric.GRPC || metric.GRPCStatus != grpcUnimplemented {
+		t.Fatalf("expected the metric to record grpc-status 12, got %d", metric.GRPCStatus)
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ComponentHealth is the readiness of a single component of the app, along
+// with a message describing why it is not ready.
+type ComponentHealth struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is the readiness of the app as a whole, which is ready only
+// when each of its components are.
+type HealthReport struct {
+	Ready      bool               `json:"ready"`
+	Components []*ComponentHealth `json:"components"`
+}
+
+func (h *HealthReport) add(name string, ready bool, message string) {
+	component := &ComponentHealth{
+		Name:  name,
+		Ready: ready,
+	}
+	if !ready {
+		component.Message = message
+		h.Ready = false
+	}
+	h.Components = append(h.Components, component)
+}
+
+// NewHealthHandler returns an http.Handler serving `/healthz` and `/readyz`.
+// `/healthz` responds with a 200 whenever the process is able to serve the
+// request, while `/readyz` responds with a 503 until the app is ready. Both
+// write out the HealthReport as JSON.
+func NewHealthHandler(report func() *HealthReport) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, req *http.Request) {
+		writeHealthResponse(rw, 200, report())
+	})
+
+	mux.HandleFunc("GET /readyz", func(rw http.ResponseWriter, req *http.Request) {
+		health := report()
+		code := 200
+		if !health.Ready {
+			code = 503
+		}
+		writeHealthResponse(rw, code, health)
+	})
+
+	return mux
+}
+
+func writeHealthResponse(rw http.ResponseWriter, code int, health *HealthReport) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(health)
+}
+
+// pluginHealth reports a plugin as ready once it has passed its first
+// heartbeat, which happens shortly after the plugin is started.
+func pluginHealth(report *HealthReport, manager PluginManager) {
+	name := fmt.Sprintf("%s:%s", manager.Type(), manager.Name())
+	report.add(name, !manager.LastHeartbeat().IsZero(), "awaiting first heartbeat")
+}
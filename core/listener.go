@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// ListenerConfig describes a single address that a server listens on. Any
+// protocol can have multiple listeners, each of which is served by its own
+// Server.
+type ListenerConfig struct {
+	Protocol gatekeeper.Protocol
+
+	// Network is either `tcp` or `unix`
+	Network string
+
+	// Address is a host:port pair for tcp listeners, such as `:8000`,
+	// `127.0.0.1:8000` or `[::1]:8000`, and a socket path for unix
+	// listeners.
+	Address string
+
+	// ProxyProtocol accepts PROXY protocol headers on the listener when
+	// set, so that requests have the address of the client which connected
+	// to a load balancer in front of gatekeeper
+	ProxyProtocol *ProxyProtocolConfig
+
+	// TrustedProxies are the networks whose `Forwarded` and X-Forwarded-*
+	// headers are trusted, and passed along to backends; these headers
+	// are replaced on requests from anywhere else
+	TrustedProxies []*net.IPNet
+}
+
+func (l ListenerConfig) String() string {
+	if l.Network == "unix" {
+		return fmt.Sprintf("%s unix:%s", l.Protocol, l.Address)
+	}
+	return fmt.Sprintf("%s %s", l.Protocol, l.Address)
+}
+
+// ParseListenerConfig parses a listen address for the given protocol.
+// Addresses prefixed with `unix:` are treated as unix domain socket paths,
+// everything else must be a host:port pair.
+func ParseListenerConfig(protocol gatekeeper.Protocol, address string) (ListenerConfig, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		if path == "" {
+			return ListenerConfig{}, fmt.Errorf("%s: %s", InvalidListenerError, address)
+		}
+
+		return ListenerConfig{
+			Protocol: protocol,
+			Network:  "unix",
+			Address:  path,
+		}, nil
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return ListenerConfig{}, fmt.Errorf("%s: %s", InvalidListenerError, address)
+	}
+
+	return ListenerConfig{
+		Protocol: protocol,
+		Network:  "tcp",
+		Address:  address,
+	}, nil
+}
+
+// hasTLSListener returns true when any of the listeners terminate TLS
+func hasTLSListener(listeners []ListenerConfig) bool {
+	for _, listener := range listeners {
+		if listener.Protocol.IsTLS() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listen opens a net.Listener for the config. Listeners inherited from a
+// parent process during an upgrade are used when available, otherwise any
+// stale unix socket left behind at the socket path by a previous process is
+// removed before listening.
+func listen(config ListenerConfig) (net.Listener, error) {
+	if listener, ok := inherited.take(config); ok {
+		return listener, nil
+	}
+
+	if config.Network == "unix" {
+		if info, err := os.Stat(config.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(config.Address); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return net.Listen(config.Network, config.Address)
+}
+
+// listenerFile returns a duplicate of the listener's socket, which remains
+// open after the listener is closed.
+func listenerFile(listener net.Listener) (*os.File, error) {
+	switch listener := listener.(type) {
+	case *net.TCPListener:
+		return listener.File()
+	case *net.UnixListener:
+		return listener.File()
+	}
+
+	return nil, InvalidListenerError
+}
+
+// keepSocketPath stops a unix listener from removing its socket path when it
+// is closed, once the path belongs to the new process of an upgrade.
+func keepSocketPath(listener net.Listener) {
+	if listener, ok := listener.(*net.UnixListener); ok {
+		listener.SetUnlinkOnClose(false)
+	}
+}
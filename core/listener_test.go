@@ -0,0 +1,88 @@
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestParseListenerConfig(t *testing.T) {
+	testCases := []struct {
+		address string
+		network string
+		parsed  string
+		valid   bool
+	}{
+		{":8000", "tcp", ":8000", true},
+		{"127.0.0.1:8000", "tcp", "127.0.0.1:8000", true},
+		{"[::1]:8000", "tcp", "[::1]:8000", true},
+		{"[::]:0", "tcp", "[::]:0", true},
+		{"unix:/var/run/gatekeeper.sock", "unix", "/var/run/gatekeeper.sock", true},
+		{"unix:relative.sock", "unix", "relative.sock", true},
+
+		{"", "", "", false},
+		{"unix:", "", "", false},
+		{"8000", "", "", false},
+		{"::1:8000", "", "", false},
+		{"[::1]", "", "", false},
+		{"/var/run/gatekeeper.sock", "", "", false},
+	}
+
+	for _, testCase := range testCases {
+		config, err := ParseListenerConfig(gatekeeper.HTTPPublic, testCase.address)
+		if !testCase.valid {
+			if err == nil || !strings.HasPrefix(err.Error(), InvalidListenerError.Error()) {
+				t.Fatalf("%q: expected InvalidListenerError, got %v", testCase.address, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q: %v", testCase.address, err)
+		}
+		if config.Protocol != gatekeeper.HTTPPublic || config.Network != testCase.network || config.Address != testCase.parsed {
+			t.Fatalf("%q: expected %s %s, got %s %s", testCase.address, testCase.network, testCase.parsed, config.Network, config.Address)
+		}
+	}
+}
+
+func TestListen_removesStaleUnixSockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gatekeeper.sock")
+	config := ListenerConfig{Protocol: gatekeeper.HTTPPublic, Network: "unix", Address: path}
+
+	// a socket left behind by a process which didn't close its listener
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listen(config)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// files which aren't sockets are never removed
+	regular := filepath.Join(t.TempDir(), "gatekeeper.sock")
+	if err := os.WriteFile(regular, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(ListenerConfig{Network: "unix", Address: regular}); err == nil {
+		t.Fatal("expected listening over a regular file to fail")
+	}
+	if _, err := os.Stat(regular); err != nil {
+		t.Fatalf("expected the file to be kept, got %v", err)
+	}
+}
@@ -17,6 +17,7 @@ type MetricWriterClient interface {
 	PluginMetric(*gatekeeper.PluginMetric)
 	RequestMetric(*gatekeeper.RequestMetric)
 	UpstreamMetric(*gatekeeper.UpstreamMetric)
+	ConnectionMetric(*gatekeeper.ConnectionMetric)
 }
 
 type MetricWriter interface {
@@ -51,6 +52,10 @@ type upstreamMetricsReceiver interface {
 	WriteUpstreamMetrics([]*gatekeeper.UpstreamMetric) []error
 }
 
+type connectionMetricsReceiver interface {
+	WriteConnectionMetrics([]*gatekeeper.ConnectionMetric) []error
+}
+
 func NewMetricWriter(bufferSize int, flushInterval time.Duration) MetricWriter {
 	return &metricWriter{
 		bufferSize:    bufferSize,
@@ -111,6 +116,10 @@ func (m *metricWriter) UpstreamMetric(event *gatekeeper.UpstreamMetric) {
 
 }
 
+func (m *metricWriter) ConnectionMetric(event *gatekeeper.ConnectionMetric) {
+	m.bufferCh <- event
+}
+
 func (m *metricWriter) worker() {
 	timer := time.NewTimer(m.flushInterval)
 
@@ -154,6 +163,7 @@ func (m *metricWriter) flush(buffer []gatekeeper.Metric) {
 	pluginMetrics := make([]*gatekeeper.PluginMetric, 0, m.bufferSize)
 	requestMetrics := make([]*gatekeeper.RequestMetric, 0, m.bufferSize)
 	upstreamMetrics := make([]*gatekeeper.UpstreamMetric, 0, m.bufferSize)
+	connectionMetrics := make([]*gatekeeper.ConnectionMetric, 0, m.bufferSize)
 
 	// bucket metrics by their type
 	for _, metric := range buffer {
@@ -168,6 +178,8 @@ func (m *metricWriter) flush(buffer []gatekeeper.Metric) {
 			requestMetrics = append(requestMetrics, metric.(*gatekeeper.RequestMetric))
 		case *gatekeeper.UpstreamMetric:
 			upstreamMetrics = append(upstreamMetrics, metric.(*gatekeeper.UpstreamMetric))
+		case *gatekeeper.ConnectionMetric:
+			connectionMetrics = append(connectionMetrics, metric.(*gatekeeper.ConnectionMetric))
 		default:
 			gatekeeper.ProgrammingError("unknown buffered metric")
 		}
@@ -228,6 +240,15 @@ func (m *metricWriter) flush(buffer []gatekeeper.Metric) {
 						return (&MultiError{errs: errs}).ToErr()
 					})
 				}
+
+				// write connection metrics, which only TCP upstreams
+				// emit, so most flushes have none
+				if _, ok := plugin.(connectionMetricsReceiver); ok && len(connectionMetrics) > 0 {
+					pluginManager.Call("WriteConnectionMetrics", func(plugin Plugin) error {
+						errs := plugin.(connectionMetricsReceiver).WriteConnectionMetrics(connectionMetrics)
+						return (&MultiError{errs: errs}).ToErr()
+					})
+				}
 			})
 		}(pluginManager)
 	}
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"os/exec"
 	"strings"
 	"time"
@@ -22,6 +24,26 @@ var InvalidPluginArgs = errors.New("invalid plugin args")
 var InvalidPluginTimeoutError = errors.New("invalid plugin-timeout")
 var InvalidProxyTimeoutError = errors.New("invalid proxy-timeout")
 
+var NoListenersError = errors.New("at least one listener required")
+var InvalidListenerError = errors.New("invalid listen address")
+
+var TLSCertificateRequiredError = errors.New("tls-cert and tls-key required for https servers")
+var TLSKeyPairMismatchError = errors.New("tls-cert and tls-key counts do not match")
+var InvalidTLSVersionError = errors.New("invalid tls-min-version")
+var InvalidCipherSuiteError = errors.New("invalid tls-cipher-suites")
+var HTTP2CipherSuiteRequiredError = errors.New("tls-cipher-suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 when http2 is enabled")
+var InvalidTLSClientCAError = errors.New("no certificates found in tls-client-ca")
+
+var InvalidCIDRError = errors.New("invalid cidr")
+var ProxyProtocolTrustedCIDRsRequiredError = errors.New("proxy-protocol-trusted-cidrs required with proxy-protocol")
+
+// TLSCertificate is a certificate / private key pair, on disk, which is served
+// by the https servers.
+type TLSCertificate struct {
+	CertFile string
+	KeyFile  string
+}
+
 type Options struct {
 	// optional plugin configuration
 	RouterPlugin     string
@@ -44,18 +66,38 @@ type Options struct {
 	MetricBufferSize    uint
 	MetricFlushInterval time.Duration
 
-	// server configurations
-	HTTPPublic     bool
-	HTTPPublicPort uint
+	// server configurations; a server is started for each listener
+	Listeners []ListenerConfig
 
-	HTTPInternal     bool
-	HTTPInternalPort uint
+	// TLS configuration for the https servers. When more than one
+	// certificate is configured, the certificate is selected by the SNI
+	// server name the client sends, falling back to the first certificate.
+	TLSCertificates []TLSCertificate
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
 
-	HTTPSPublic     bool
-	HTTPSPublicPort uint
+	// interval on which certificate files are checked for changes and
+	// reloaded; zero disables reloading on file change
+	TLSReloadInterval time.Duration
 
-	HTTPSInternal     bool
-	HTTPSInternalPort uint
+	// CA bundle which the client certificates of requests to the
+	// https-internal servers are verified against. Clients are only asked
+	// for a certificate when it is set.
+	TLSClientCAFile string
+
+	// HTTP2 serves HTTP/2 to clients of the https servers which negotiate
+	// it, and H2C serves HTTP/2 to clients of the http servers which
+	// connect with prior knowledge
+	HTTP2 bool
+	H2C   bool
+
+	// host which the listener of each TCP upstream is bound to, on the
+	// upstream's port; all interfaces when empty
+	TCPListenHost string
+
+	// TCPProxyProtocol accepts PROXY protocol headers on the listeners of
+	// TCP upstreams when set
+	TCPProxyProtocol *ProxyProtocolConfig
 
 	// Default proxying behavior
 	DefaultProxyTimeout      time.Duration
@@ -65,6 +107,19 @@ type Options struct {
 	// Internal configuration
 	PluginTimeout    time.Duration
 	ProfilerInterval time.Duration
+
+	// time to wait for a new process to become ready during an upgrade
+	UpgradeTimeout time.Duration
+
+	// the admin api is served on AdminListener when set, and is mounted
+	// under AdminPrefix on the internal servers when AdminOnInternal is
+	// true
+	AdminListener   *ListenerConfig
+	AdminOnInternal bool
+
+	// health checks are served on HealthListener when set, as well as
+	// alongside the admin api
+	HealthListener *ListenerConfig
 }
 
 func ValidatePlugins(rawCmds []string) ([]string, error) {
@@ -83,3 +138,80 @@ func ValidatePlugins(rawCmds []string) ([]string, error) {
 
 	return cmds, nil
 }
+
+// ParseTLSCertificates pairs up certificate and key paths by index
+func ParseTLSCertificates(certFiles, keyFiles []string) ([]TLSCertificate, error) {
+	if len(certFiles) != len(keyFiles) {
+		return []TLSCertificate(nil), TLSKeyPairMismatchError
+	}
+
+	certificates := make([]TLSCertificate, len(certFiles))
+	for idx, certFile := range certFiles {
+		certificates[idx] = TLSCertificate{
+			CertFile: certFile,
+			KeyFile:  keyFiles[idx],
+		}
+	}
+
+	return certificates, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a version string such as `1.2` into its crypto/tls constant
+func ParseTLSVersion(value string) (uint16, error) {
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, InvalidTLSVersionError
+	}
+
+	return version, nil
+}
+
+// ParseCipherSuites parses cipher suite names, as named by crypto/tls, into
+// their IDs. An empty list leaves the crypto/tls defaults in place.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return []uint16(nil), nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, len(names))
+	for idx, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return []uint16(nil), fmt.Errorf("%s: %s", InvalidCipherSuiteError, name)
+		}
+		suites[idx] = id
+	}
+
+	return suites, nil
+}
+
+// ValidateHTTP2CipherSuites checks that the cipher suites include one which
+// HTTP/2 requires, per https://tools.ietf.org/html/rfc7540#section-9.2.2,
+// without which net/http refuses to serve HTTP/2. Cipher suites don't apply to
+// TLS 1.3, so they aren't checked when it is the minimum version, nor when the
+// crypto/tls defaults are used.
+func ValidateHTTP2CipherSuites(suites []uint16, minVersion uint16) error {
+	if len(suites) == 0 || minVersion >= tls.VersionTLS13 {
+		return nil
+	}
+
+	for _, suite := range suites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return nil
+		}
+	}
+
+	return HTTP2CipherSuiteRequiredError
+}
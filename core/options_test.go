@@ -0,0 +1,34 @@
+package core
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestValidateHTTP2CipherSuites(t *testing.T) {
+	testCases := []struct {
+		names      []string
+		minVersion uint16
+		err        error
+	}{
+		{nil, tls.VersionTLS12, nil},
+		{[]string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, tls.VersionTLS12, nil},
+		{[]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, tls.VersionTLS12, nil},
+		{[]string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, tls.VersionTLS12, HTTP2CipherSuiteRequiredError},
+		{[]string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, tls.VersionTLS13, nil},
+	}
+
+	for idx, testCase := range testCases {
+		suites, err := ParseCipherSuites(testCase.names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateHTTP2CipherSuites(suites, testCase.minVersion); err != testCase.err {
+			t.Fatalf("case %d: expected %v, got %v", idx, testCase.err, err)
+		}
+	}
+
+	if _, err := ParseCipherSuites([]string{"TLS_NOT_A_SUITE"}); err == nil {
+		t.Fatal("expected an unknown cipher suite to be invalid")
+	}
+}
@@ -34,6 +34,12 @@ type PluginManager interface {
 	// information about the underlying plugin
 	Type() PluginType
 	Name() string
+	Cmd() string
+
+	// LastHeartbeat returns the time of the last successful heartbeat,
+	// which is zero until the plugin has passed its first heartbeat
+	LastHeartbeat() time.Time
+	Restarts() uint
 }
 
 func NewPluginManager(cmd string, args map[string]interface{}, pluginType PluginType, metricWriter MetricWriterClient) PluginManager {
@@ -54,7 +60,8 @@ func NewPluginManager(cmd string, args map[string]interface{}, pluginType Plugin
 		stopCh: make(chan struct{}),
 		doneCh: make(chan struct{}),
 
-		HookManager: NewHookManager(),
+		SyncStartStopper: &syncStartStopper{},
+		HookManager:      NewHookManager(),
 	}
 }
 
@@ -68,6 +75,9 @@ type pluginManager struct {
 
 	instance Plugin
 
+	lastHeartbeat time.Time
+	restarts      uint
+
 	workers           uint
 	callTimeout       time.Duration
 	callRetries       uint
@@ -198,6 +208,22 @@ func (p *pluginManager) Name() string {
 	return p.pluginName
 }
 
+func (p *pluginManager) Cmd() string {
+	return p.pluginCmd
+}
+
+func (p *pluginManager) LastHeartbeat() time.Time {
+	p.RLock()
+	defer p.RUnlock()
+	return p.lastHeartbeat
+}
+
+func (p *pluginManager) Restarts() uint {
+	p.RLock()
+	defer p.RUnlock()
+	return p.restarts
+}
+
 // build builds a plugin instance, configuring and starting it
 func (p *pluginManager) buildInstance() error {
 	// fetch the plugin instance
@@ -253,9 +279,16 @@ func (p *pluginManager) heartbeat() error {
 	})
 
 	if err == nil {
+		p.Lock()
+		p.lastHeartbeat = time.Now()
+		p.Unlock()
 		return nil
 	}
 
+	p.Lock()
+	p.restarts += 1
+	p.Unlock()
+
 	p.eventMetric(gatekeeper.PluginRestartedEvent)
 	p.buildInstance()
 	p.startInstance()
@@ -2,10 +2,14 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/jonmorehouse/gatekeeper/gatekeeper"
@@ -13,21 +17,32 @@ import (
 
 type Proxier interface {
 	Proxy(http.ResponseWriter, *http.Request, *gatekeeper.Request, *gatekeeper.Upstream, *gatekeeper.Backend, *gatekeeper.RequestMetric) error
+
+	// ProxyUpgrade proxies requests which switch protocols, such as
+	// websockets, splicing the client and backend connections together
+	ProxyUpgrade(http.ResponseWriter, *http.Request, *gatekeeper.Request, *gatekeeper.Upstream, *gatekeeper.Backend, *gatekeeper.RequestMetric) error
 }
 
 type proxier struct {
 	defaultTimeout time.Duration
 
 	modifier     Modifier
+	transports   TransportManager
 	metricWriter MetricWriterClient
 }
 
-func NewProxier(modifier Modifier, metricWriter MetricWriterClient) Proxier {
-	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 200
+// NewProxier returns a Proxier which proxies requests to backends, timing out
+// requests to upstreams without a Timeout of their own after defaultTimeout.
+func NewProxier(defaultTimeout time.Duration, modifier Modifier, transports TransportManager, metricWriter MetricWriterClient) Proxier {
+	if defaultTimeout == 0 {
+		defaultTimeout = 5 * time.Second
+	}
+
 	return &proxier{
 		modifier:       modifier,
+		transports:     transports,
 		metricWriter:   metricWriter,
-		defaultTimeout: 5 * time.Second,
+		defaultTimeout: defaultTimeout,
 	}
 }
 
@@ -43,33 +58,55 @@ func (p *proxier) Proxy(rw http.ResponseWriter,
 		return BackendAddressError
 	}
 
+	if isGRPCRequest(req.Header) && upstream.BackendProtocol == gatekeeper.HTTP1BackendProtocol {
+		p.writeProxyError(rw, req, GRPCBackendProtocolError, metric)
+		return nil
+	}
+
 	timeout := upstream.Timeout
 	if timeout == time.Millisecond*0 {
 		timeout = p.defaultTimeout
 	}
 
-	// build out the request and the proxy that will be used to perform the request
+	// build out the request and the proxy that will be used to perform the
+	// request, tracing the connection to the backend
 	p.modifyProxyRequest(httpReq, req)
-	proxy := httputil.NewSingleHostReverseProxy(backendAddress)
-	proxy.Transport = NewRoundTripper(timeout, func(httpResp *http.Response, latency time.Duration, err error) (*http.Response, error) {
-		var startTS time.Time
-		if httpResp == nil {
-			httpResp = &http.Response{
-				StatusCode: 500,
-			}
-		}
+	if upstream.ProxyProtocol != 0 {
+		httpReq = httpReq.WithContext(withProxyProtocolAddrs(httpReq))
+	}
+	trace := newRequestTrace()
+	httpReq = httpReq.WithContext(httptrace.WithClientTrace(httpReq.Context(), trace.ClientTrace()))
 
-		resp := gatekeeper.NewResponse(httpResp)
+	// the backend response is kept so that its trailers can be read once
+	// the body has been proxied
+	var backendResp *http.Response
+
+	streaming := func(httpResp *http.Response) bool {
+		return isStreamingResponse(upstream, httpResp)
+	}
+
+	// streaming upstreams flush every write to the client as soon as it is
+	// read from the backend; the ReverseProxy does the same for streaming
+	// responses from other upstreams
+	proxy := httputil.NewSingleHostReverseProxy(backendAddress)
+	if upstream.Streaming {
+		proxy.FlushInterval = -1
+	}
+	proxy.Transport = NewRoundTripper(p.transports.Transport(upstream), timeout, func(httpResp *http.Response, latency time.Duration, err error) (*http.Response, error) {
+		trace.writeMetric(metric)
+		metric.ProxyLatency = latency
 
+		// errors reaching the backend are written out by the
+		// ErrorHandler below
 		if err != nil {
-			startTS = time.Now()
-			resp, err = p.modifier.ModifyErrorResponse(err, req, resp)
-			metric.ErrorResponseModifierLatency = time.Now().Sub(startTS)
-			goto finished
+			return nil, trace.proxyError(err)
 		}
+		metric.BackendProto = httpResp.Proto
+		backendResp = httpResp
 
 		// Attempt to modify the response
-		startTS = time.Now()
+		resp := gatekeeper.NewResponse(httpResp)
+		startTS := time.Now()
 		resp, err = p.modifier.ModifyResponse(req, resp)
 		metric.ResponseModifierLatency = time.Now().Sub(startTS)
 		if err != nil {
@@ -78,12 +115,6 @@ func (p *proxier) Proxy(rw http.ResponseWriter,
 			metric.ErrorResponseModifierLatency = time.Now().Sub(startTS)
 		}
 
-	finished:
-		metric.ProxyLatency = latency
-		metric.Response = resp
-		metric.Error = gatekeeper.NewError(err)
-		resp.Error = gatekeeper.NewError(err)
-
 		// in the rare case that a plugin failed and returned a nil
 		// response, create a generic ErrorResponse denoting an
 		// internal error
@@ -91,14 +122,77 @@ func (p *proxier) Proxy(rw http.ResponseWriter,
 			resp = gatekeeper.NewErrorResponse(500, InternalError)
 		}
 
+		// streamed bodies are never buffered, so any body override
+		// from the modifiers is dropped
+		if streaming(httpResp) {
+			metric.Streamed = true
+			resp.Body = nil
+		}
+
+		metric.Response = resp
+		metric.Error = gatekeeper.NewError(err)
+		resp.Error = gatekeeper.NewError(err)
+
 		p.responseToHTTPResponse(resp, httpResp)
-		return httpResp, err
+		return httpResp, nil
 	})
+	proxy.ErrorHandler = func(rw http.ResponseWriter, _ *http.Request, err error) {
+		p.writeProxyError(rw, req, err, metric)
+	}
 
 	proxy.ServeHTTP(rw, httpReq)
+	if backendResp != nil && isGRPCRequest(req.Header) {
+		recordGRPCStatus(metric, backendResp.Header, backendResp.Trailer)
+	}
 	return nil
 }
 
+// writeProxyError writes out an error response for a request which failed to
+// reach the backend, passing it through the modifier's ModifyErrorResponse.
+func (p *proxier) writeProxyError(rw http.ResponseWriter, req *gatekeeper.Request, err error, metric *gatekeeper.RequestMetric) {
+	code := 502
+	switch err {
+	case ProxyTimeoutError:
+		code = 504
+	case ClientCanceledError:
+		// the client is no longer around to receive this response; it is
+		// only used to record the outcome in the metric
+		code = 499
+		metric.ClientCanceled = true
+	}
+	metric.BackendTLSHandshakeFailed = errors.Is(err, BackendTLSHandshakeError)
+
+	metric.Error = gatekeeper.NewError(err)
+
+	startTS := time.Now()
+	resp, modifierErr := p.modifier.ModifyErrorResponse(err, req, gatekeeper.NewErrorResponse(code, err))
+	metric.ErrorResponseModifierLatency = time.Now().Sub(startTS)
+	if modifierErr != nil || resp == nil {
+		resp = gatekeeper.NewErrorResponse(500, ModifierPluginError)
+	}
+
+	if isGRPCRequest(req.Header) {
+		resp = grpcErrorResponse(err, resp)
+		recordGRPCStatus(metric, resp.Header, nil)
+	}
+
+	metric.Response = resp
+	writeResponse(rw, resp)
+}
+
+// isStreamingResponse returns true when the response should be streamed to
+// the client; either because the upstream always streams, or because the
+// response is a server-sent event stream or has no known length, such as a
+// chunked response.
+func isStreamingResponse(upstream *gatekeeper.Upstream, resp *http.Response) bool {
+	if upstream.Streaming || resp.ContentLength == -1 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
 func (p *proxier) modifyProxyRequest(httpReq *http.Request, req *gatekeeper.Request) {
 	if req.UpstreamMatchType == gatekeeper.PrefixUpstreamMatch {
 		httpReq.URL.Path = req.PrefixlessPath
@@ -126,14 +220,33 @@ func (p *proxier) responseToHTTPResponse(resp *gatekeeper.Response, httpResp *ht
 	httpResp.ContentLength = resp.ContentLength
 	httpResp.TransferEncoding = resp.TransferEncoding
 	httpResp.Close = resp.Close
-	httpResp.Trailer = resp.Trailer
+
+	// trailers are filled into the backend response's Trailer map once its
+	// body has been read, so the map itself is kept and only extended with
+	// any trailers the modifiers set
+	if httpResp.Trailer == nil {
+		httpResp.Trailer = resp.Trailer
+	} else {
+		for trailer, values := range resp.Trailer {
+			if len(values) > 0 {
+				httpResp.Trailer[trailer] = values
+			}
+		}
+	}
 
 	// if the plugin returns an override response, then go ahead and
 	// consume the entirety of the httpResponse's reader, and close it to
-	// prevent backing up the connection queue on the default transport.
+	// prevent backing up the connection queue on the default transport. The
+	// backend's length no longer applies to the overridden body.
 	if resp.Body != nil {
 		ioutil.ReadAll(httpResp.Body)
 		httpResp.Body.Close()
 		httpResp.Body = ioutil.NopCloser(bytes.NewReader(resp.Body))
+		httpResp.ContentLength = int64(len(resp.Body))
+		httpResp.TransferEncoding = nil
+		if httpResp.Header == nil {
+			httpResp.Header = make(http.Header)
+		}
+		httpResp.Header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
 	}
 }
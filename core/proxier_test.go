@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// bodyModifier overrides the body of every response
+type bodyModifier struct {
+	localModifier
+	body string
+}
+
+func (m *bodyModifier) ModifyResponse(req *gatekeeper.Request, resp *gatekeeper.Response) (*gatekeeper.Response, error) {
+	resp.Body = []byte(m.body)
+	return resp, nil
+}
+
+// newStreamingProxy proxies every request to the backend through the
+// modifier, sending each request's metric on metricCh once Proxy returns
+func newStreamingProxy(t *testing.T, backendURL string, modifier Modifier, metricCh chan *gatekeeper.RequestMetric) *httptest.Server {
+	proxier := NewProxier(time.Second, modifier, NewTransportManager(NewBroadcaster(), Options{}), NewMetricWriter(10, time.Second))
+	upstream := &gatekeeper.Upstream{ID: "events"}
+	backend := &gatekeeper.Backend{ID: "events-1", Address: backendURL}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, httpReq *http.Request) {
+		metric := &gatekeeper.RequestMetric{}
+		if err := proxier.Proxy(rw, httpReq, gatekeeper.NewRequest(httpReq, gatekeeper.HTTPPublic), upstream, backend, metric); err != nil {
+			t.Error(err)
+		}
+		metricCh <- metric
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestProxierProxy_streamsEventsAsTheyAreWritten(t *testing.T) {
+	releaseCh := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// a known length would otherwise be buffered
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Content-Length", "22")
+		io.WriteString(rw, "data: one\n\n")
+		rw.(http.Flusher).Flush()
+
+		<-releaseCh
+		io.WriteString(rw, "data: two\n\n")
+	}))
+	defer backend.Close()
+
+	metricCh := make(chan *gatekeeper.RequestMetric, 1)
+	proxy := newStreamingProxy(t, backend.URL, NewLocalModifier(), metricCh)
+
+	resp, err := http.Get(proxy.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the first event arrives while the backend is still blocked
+	eventCh := make(chan string, 1)
+	reader := bufio.NewReader(resp.Body)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		eventCh <- line
+	}()
+
+	select {
+	case event := <-eventCh:
+		if event != "data: one\n" {
+			t.Fatalf("expected the first event, got %q", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the first event before the backend finished")
+	}
+
+	close(releaseCh)
+	if rest, _ := io.ReadAll(reader); string(rest) != "\ndata: two\n\n" {
+		t.Fatalf("expected the second event, got %q", rest)
+	}
+	if metric := <-metricCh; !metric.Streamed {
+		t.Fatal("expected the response to be recorded as streamed")
+	}
+}
+
+func TestProxierProxy_skipsBodyOverridesForStreamingResponses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/events":
+			rw.Header().Set("Content-Type", "text/event-stream")
+		case "/chunked":
+			rw.(http.Flusher).Flush()
+		default:
+			rw.Header().Set("Content-Type", "application/json")
+		}
+		io.WriteString(rw, "original")
+	}))
+	defer backend.Close()
+
+	metricCh := make(chan *gatekeeper.RequestMetric, 1)
+	proxy := newStreamingProxy(t, backend.URL, &bodyModifier{body: "overridden"}, metricCh)
+
+	testCases := []struct {
+		path     string
+		body     string
+		streamed bool
+	}{
+		{"/json", "overridden", false},
+		{"/events", "original", true},
+		{"/chunked", "original", true},
+	}
+
+	for _, testCase := range testCases {
+		resp, err := http.Get(proxy.URL + testCase.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != testCase.body {
+			t.Fatalf("%s: expected %q, got %q %v", testCase.path, testCase.body, body, err)
+		}
+
+		if metric := <-metricCh; metric.Streamed != testCase.streamed {
+			t.Fatalf("%s: expected streamed to be %t", testCase.path, testCase.streamed)
+		}
+	}
+}
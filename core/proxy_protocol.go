@@ -0,0 +1,404 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the PROXY protocol, as described by
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt, prefixes a
+// connection with the addresses of the client connection which a proxy or
+// load balancer accepted.
+const (
+	proxyProtocolV1MaxLength = 107
+
+	proxyProtocolV2Version = 0x20
+	proxyProtocolV2Local   = 0x00
+	proxyProtocolV2Proxy   = 0x01
+	proxyProtocolV2TCP4    = 0x11
+	proxyProtocolV2TCP6    = 0x21
+
+	defaultProxyProtocolHeaderTimeout = 5 * time.Second
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolConfig enables accepting a PROXY protocol v1 or v2 header on
+// a listener, so that the address of the client which connected to a load
+// balancer in front of gatekeeper is used as the connection's remote address.
+type ProxyProtocolConfig struct {
+	// TrustedCIDRs are the networks, such as a load balancer's, which
+	// connections must send a PROXY header from. Connections from any
+	// other source are served as they are, with their own address, so
+	// that nobody else can spoof their address with a PROXY header. When
+	// empty, no source is trusted.
+	TrustedCIDRs []*net.IPNet
+
+	// time allowed for a connection to send its PROXY header
+	HeaderTimeout time.Duration
+}
+
+func (p *ProxyProtocolConfig) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, cidr := range p.TrustedCIDRs {
+		if cidr.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseCIDRs parses networks in CIDR notation, such as `10.0.0.0/8`, treating
+// a bare IP address as a network of just that address.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, len(values))
+	for idx, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return []*net.IPNet(nil), fmt.Errorf("%s: %s", InvalidCIDRError, value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			cidrs[idx] = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(value)
+		if err != nil {
+			return []*net.IPNet(nil), fmt.Errorf("%s: %s", InvalidCIDRError, value)
+		}
+		cidrs[idx] = cidr
+	}
+
+	return cidrs, nil
+}
+
+// newProxyProtocolListener wraps the listener so that connections from
+// trusted sources have their PROXY header read and their addresses replaced
+// by the ones it carries.
+func newProxyProtocolListener(listener net.Listener, config *ProxyProtocolConfig) net.Listener {
+	return &proxyProtocolListener{
+		Listener: listener,
+		config:   config,
+	}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	config *ProxyProtocolConfig
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !l.config.trusted(conn.RemoteAddr()) {
+		return conn, err
+	}
+
+	timeout := l.config.HeaderTimeout
+	if timeout == 0 {
+		timeout = defaultProxyProtocolHeaderTimeout
+	}
+
+	return &proxyProtocolConn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// proxyProtocolConn reads the PROXY header the first time the connection is
+// read from or its addresses are asked for, rather than when it is accepted,
+// so that a slow client can't hold up the listener's Accept loop. When the
+// header is invalid, every Read returns the error.
+type proxyProtocolConn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remoteAddr, c.localAddr, c.err = readProxyProtocolHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+
+	return c.err
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.readHeader() != nil || c.remoteAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.readHeader() != nil || c.localAddr == nil {
+		return c.Conn.LocalAddr()
+	}
+	return c.localAddr
+}
+
+// CloseWrite half-closes the underlying connection when it supports it, so
+// that splice is able to signal EOF through it.
+func (c *proxyProtocolConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// readProxyProtocolHeader reads a v1 or v2 PROXY header, returning the source
+// and destination addresses it carries. Both are nil for headers which don't
+// carry TCP addresses, such as v1 `UNKNOWN` and v2 `LOCAL` headers.
+func readProxyProtocolHeader(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	// the shortest header of either version is at least as long as the v2
+	// signature
+	prefix, err := reader.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	if bytes.Equal(prefix, proxyProtocolV2Signature) {
+		return readProxyProtocolV2Header(reader)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyProtocolV1Header(reader)
+	}
+
+	return nil, nil, InvalidProxyProtocolHeaderError
+}
+
+// readProxyProtocolV1Header reads a header such as
+// `PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n`
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != nil || len(line) > proxyProtocolV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	src, err := parseProxyProtocolV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+func parseProxyProtocolV1Addr(host, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, InvalidProxyProtocolHeaderError
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(portNum)}, nil
+}
+
+// readProxyProtocolV2Header reads a binary header; the signature, followed by
+// the version and command, the address family, the length of the remaining
+// header and then the addresses, followed by any TLVs, which are skipped.
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	versionCommand := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	if versionCommand&0xF0 != proxyProtocolV2Version {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	switch versionCommand & 0x0F {
+	case proxyProtocolV2Local:
+		return nil, nil, nil
+	case proxyProtocolV2Proxy:
+	default:
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	var ipLen int
+	switch family {
+	case proxyProtocolV2TCP4:
+		ipLen = net.IPv4len
+	case proxyProtocolV2TCP6:
+		ipLen = net.IPv6len
+	default:
+		// unix sockets and datagrams don't have tcp addresses
+		return nil, nil, nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, InvalidProxyProtocolHeaderError
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+
+	return src, dst, nil
+}
+
+// writeProxyProtocolHeader writes a PROXY header of the given version, 1 or
+// 2, to a backend connection. A header which carries no addresses is written
+// when either address isn't a tcp address.
+func writeProxyProtocolHeader(w io.Writer, version uint, src, dst net.Addr) error {
+	srcAddr, srcOK := src.(*net.TCPAddr)
+	dstAddr, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK
+
+	// both addresses must be of the same family, so IPv4 addresses are
+	// sent as IPv4-mapped IPv6 addresses alongside an IPv6 address
+	var srcIP, dstIP net.IP
+	tcp4 := false
+	if known {
+		srcIP, dstIP = srcAddr.IP.To4(), dstAddr.IP.To4()
+		tcp4 = srcIP != nil && dstIP != nil
+		if !tcp4 {
+			srcIP, dstIP = srcAddr.IP.To16(), dstAddr.IP.To16()
+		}
+	}
+
+	switch version {
+	case 1:
+		if !known {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+
+		family := "TCP6"
+		if tcp4 {
+			family = "TCP4"
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcAddr.Port, dstAddr.Port)
+		return err
+	case 2:
+		header := append([]byte(nil), proxyProtocolV2Signature...)
+		if !known {
+			header = append(header, proxyProtocolV2Version|proxyProtocolV2Local, 0x00, 0x00, 0x00)
+			_, err := w.Write(header)
+			return err
+		}
+
+		family := byte(proxyProtocolV2TCP6)
+		if tcp4 {
+			family = proxyProtocolV2TCP4
+		}
+		header = append(header, proxyProtocolV2Version|proxyProtocolV2Proxy, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(2*len(srcIP)+4))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = binary.BigEndian.AppendUint16(header, uint16(srcAddr.Port))
+		header = binary.BigEndian.AppendUint16(header, uint16(dstAddr.Port))
+		_, err := w.Write(header)
+		return err
+	}
+
+	return InvalidProxyProtocolVersionError
+}
+
+type proxyProtocolAddrsKey struct{}
+
+// proxyProtocolAddrs are the client and local addresses of the connection a
+// request arrived on, which are sent in the PROXY header to the backend
+type proxyProtocolAddrs struct {
+	src net.Addr
+	dst net.Addr
+}
+
+// withProxyProtocolAddrs returns the request's context, carrying the
+// addresses of the client connection it arrived on for proxyProtocolDialer.
+func withProxyProtocolAddrs(req *http.Request) context.Context {
+	addrs := &proxyProtocolAddrs{}
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		addrs.src = addr
+	}
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		addrs.dst = addr
+	}
+
+	return context.WithValue(req.Context(), proxyProtocolAddrsKey{}, addrs)
+}
+
+// proxyProtocolDialer wraps dial, writing a PROXY header with the addresses
+// carried by the dial's context to each new connection. Connections must not
+// be shared between clients, so transports using it disable keep-alives.
+func proxyProtocolDialer(version uint, dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		var src, dst net.Addr
+		if addrs, ok := ctx.Value(proxyProtocolAddrsKey{}).(*proxyProtocolAddrs); ok {
+			src, dst = addrs.src, addrs.dst
+		}
+
+		if err := writeProxyProtocolHeader(conn, version, src, dst); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
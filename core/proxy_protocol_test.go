@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyProtocolV2Header builds a v2 header for the given command and family,
+// with the payload following its length
+func proxyProtocolV2Header(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), proxyProtocolV2Signature...)
+	header = append(header, proxyProtocolV2Version|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	tcp4Payload := append(net.IP{192, 168, 0, 1}, 192, 168, 0, 11, 0xdc, 0x04, 0x01, 0xbb)
+	tlvPayload := append(append([]byte(nil), tcp4Payload...), 0x04, 0x00, 0x03, 'a', 'b', 'c')
+
+	testCases := []struct {
+		header string
+		src    string
+		dst    string
+		err    error
+	}{
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", "192.168.0.1:56324", "192.168.0.11:443", nil},
+		{"PROXY TCP6 2001:db8::1 2001:db8::11 56324 443\r\n", "[2001:db8::1]:56324", "[2001:db8::11]:443", nil},
+		{"PROXY UNKNOWN\r\n", "", "", nil},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", "", nil},
+		{string(proxyProtocolV2Header(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4Payload)), "192.168.0.1:56324", "192.168.0.11:443", nil},
+		{string(proxyProtocolV2Header(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tlvPayload)), "192.168.0.1:56324", "192.168.0.11:443", nil},
+		{string(proxyProtocolV2Header(proxyProtocolV2Local, 0x00, nil)), "", "", nil},
+
+		// truncated headers
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324", "", "", InvalidProxyProtocolHeaderError},
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n", "", "", InvalidProxyProtocolHeaderError},
+		{string(proxyProtocolV2Header(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4Payload)[:20]), "", "", InvalidProxyProtocolHeaderError},
+		{string(proxyProtocolV2Header(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4Payload[:8])), "", "", InvalidProxyProtocolHeaderError},
+
+		// oversized and invalid headers
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443" + strings.Repeat(" ", 100) + "\r\n", "", "", InvalidProxyProtocolHeaderError},
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324 65536\r\n", "", "", InvalidProxyProtocolHeaderError},
+		{"PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n", "", "", InvalidProxyProtocolHeaderError},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", "", InvalidProxyProtocolHeaderError},
+		{string(proxyProtocolV2Header(0x02, proxyProtocolV2TCP4, tcp4Payload)), "", "", InvalidProxyProtocolHeaderError},
+	}
+
+	for idx, testCase := range testCases {
+		reader := bufio.NewReader(strings.NewReader(testCase.header + "body"))
+		src, dst, err := readProxyProtocolHeader(reader)
+		if err != testCase.err {
+			t.Fatalf("case %d: expected error %v, got %v", idx, testCase.err, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if addrString(src) != testCase.src || addrString(dst) != testCase.dst {
+			t.Fatalf("case %d: expected %s -> %s, got %v -> %v", idx, testCase.src, testCase.dst, src, dst)
+		}
+		if rest, _ := io.ReadAll(reader); string(rest) != "body" {
+			t.Fatalf("case %d: expected the header to be consumed, got %q", idx, rest)
+		}
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestWriteProxyProtocolHeader_roundTrips(t *testing.T) {
+	tcp4 := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	tcp6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::11"), Port: 443}
+	unix := &net.UnixAddr{Name: "/tmp/gatekeeper.sock", Net: "unix"}
+
+	testCases := []struct {
+		src net.Addr
+		dst net.Addr
+		// the addresses read back; IPv4 addresses alongside an IPv6 one
+		// are sent as IPv4-mapped IPv6 addresses
+		expectedSrc string
+		expectedDst string
+	}{
+		{tcp4, tcp4, "192.168.0.1:56324", "192.168.0.1:56324"},
+		{tcp6, tcp6, "[2001:db8::11]:443", "[2001:db8::11]:443"},
+		{tcp4, tcp6, "192.168.0.1:56324", "[2001:db8::11]:443"},
+		{unix, tcp4, "", ""},
+		{nil, nil, "", ""},
+	}
+
+	for _, version := range []uint{1, 2} {
+		for idx, testCase := range testCases {
+			var buf bytes.Buffer
+			if err := writeProxyProtocolHeader(&buf, version, testCase.src, testCase.dst); err != nil {
+				t.Fatal(err)
+			}
+
+			src, dst, err := readProxyProtocolHeader(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("v%d case %d: %v", version, idx, err)
+			}
+			if addrString(src) != testCase.expectedSrc || addrString(dst) != testCase.expectedDst {
+				t.Fatalf("v%d case %d: expected %s -> %s, got %v -> %v", version, idx, testCase.expectedSrc, testCase.expectedDst, src, dst)
+			}
+		}
+	}
+
+	if err := writeProxyProtocolHeader(io.Discard, 3, tcp4, tcp4); err != InvalidProxyProtocolVersionError {
+		t.Fatalf("expected InvalidProxyProtocolVersionError, got %v", err)
+	}
+}
+
+func TestProxyProtocolListener_onlyTrustsConfiguredSources(t *testing.T) {
+	testCases := []struct {
+		trustedCIDRs []string
+		remoteAddr   string
+		body         string
+	}{
+		{[]string{"127.0.0.0/8"}, "192.168.0.1:56324", "ping"},
+		{[]string{"10.0.0.0/8"}, "", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping"},
+		{nil, "", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping"},
+	}
+
+	for idx, testCase := range testCases {
+		cidrs, err := ParseCIDRs(testCase.trustedCIDRs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener := newProxyProtocolListener(rawListener, &ProxyProtocolConfig{TrustedCIDRs: cidrs})
+
+		client, err := net.Dial("tcp", rawListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(client, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping")
+		client.(*net.TCPConn).CloseWrite()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(conn)
+		if err != nil || string(body) != testCase.body {
+			t.Fatalf("case %d: expected %q, got %q %v", idx, testCase.body, body, err)
+		}
+
+		// untrusted connections keep their own address
+		expectedAddr := testCase.remoteAddr
+		if expectedAddr == "" {
+			expectedAddr = client.LocalAddr().String()
+		}
+		if conn.RemoteAddr().String() != expectedAddr {
+			t.Fatalf("case %d: expected remote address %s, got %s", idx, expectedAddr, conn.RemoteAddr())
+		}
+
+		conn.Close()
+		client.Close()
+		listener.Close()
+	}
+}
@@ -0,0 +1,202 @@
+package core
+
+import "strings"
+
+// radixTree maps path prefixes, such as `/api` or `/api/v2/billing`, to values
+// and finds the prefixes of a request path. Prefixes only match a path on a
+// segment boundary, so `/api` matches `/api` and `/api/users`, but not
+// `/apis`. Get looks up keys exactly, which also suits hostnames.
+//
+// Trees are immutable; Insert and Delete return a new tree, copying only the
+// nodes along the path to the changed key and sharing the rest with the
+// original. Trees can therefore be read concurrently without locking, while
+// a new version is being built.
+type radixTree struct {
+	root *radixNode
+}
+
+type radixNode struct {
+	// label is the part of the key between the parent node and this one
+	label    string
+	children []*radixNode
+
+	// leaf is true for nodes at the end of an inserted key
+	leaf  bool
+	value interface{}
+}
+
+func newRadixTree() *radixTree {
+	return &radixTree{
+		root: &radixNode{},
+	}
+}
+
+// clone returns a copy of the node which can be modified without affecting
+// the trees which share the original
+func (n *radixNode) clone() *radixNode {
+	clone := *n
+	clone.children = append([]*radixNode(nil), n.children...)
+	return &clone
+}
+
+// child returns the child whose label starts with the first byte of key, and
+// its index; children never share their first byte
+func (n *radixNode) child(key string) (*radixNode, int) {
+	if key == "" {
+		return nil, -1
+	}
+
+	for idx, child := range n.children {
+		if child.label[0] == key[0] {
+			return child, idx
+		}
+	}
+	return nil, -1
+}
+
+// Insert returns a tree with the value set for the prefix, replacing any
+// existing value
+func (t *radixTree) Insert(prefix string, value interface{}) *radixTree {
+	return &radixTree{root: t.root.insert(prefix, value)}
+}
+
+func (n *radixNode) insert(key string, value interface{}) *radixNode {
+	clone := n.clone()
+	if key == "" {
+		clone.leaf = true
+		clone.value = value
+		return clone
+	}
+
+	child, idx := n.child(key)
+	if child == nil {
+		clone.children = append(clone.children, &radixNode{label: key, leaf: true, value: value})
+		return clone
+	}
+
+	common := commonPrefixLen(key, child.label)
+	if common == len(child.label) {
+		clone.children[idx] = child.insert(key[common:], value)
+		return clone
+	}
+
+	// split the child's label at the point where it diverges from the
+	// key, so that the key shares the new node
+	tail := child.clone()
+	tail.label = child.label[common:]
+	split := &radixNode{
+		label:    child.label[:common],
+		children: []*radixNode{tail},
+	}
+	clone.children[idx] = split.insert(key[common:], value)
+	return clone
+}
+
+// Get returns the value for the prefix
+func (t *radixTree) Get(prefix string) (interface{}, bool) {
+	node := t.root
+	key := prefix
+
+	for key != "" {
+		child, _ := node.child(key)
+		if child == nil || !strings.HasPrefix(key, child.label) {
+			return nil, false
+		}
+		node = child
+		key = key[len(child.label):]
+	}
+
+	return node.value, node.leaf
+}
+
+// Delete returns a tree without the prefix, merging any nodes which are left
+// with a single child. It returns false when the prefix wasn't found.
+func (t *radixTree) Delete(prefix string) (*radixTree, bool) {
+	root, found := t.root.delete(prefix)
+	return &radixTree{root: root}, found
+}
+
+func (n *radixNode) delete(key string) (*radixNode, bool) {
+	if key == "" {
+		if !n.leaf {
+			return n, false
+		}
+		clone := n.clone()
+		clone.leaf = false
+		clone.value = nil
+		return clone, true
+	}
+
+	child, idx := n.child(key)
+	if child == nil || !strings.HasPrefix(key, child.label) {
+		return n, false
+	}
+
+	updated, found := child.delete(key[len(child.label):])
+	if !found {
+		return n, false
+	}
+
+	clone := n.clone()
+	switch {
+	case updated.leaf || len(updated.children) > 1:
+		clone.children[idx] = updated
+	case len(updated.children) == 0:
+		clone.children = append(clone.children[:idx], clone.children[idx+1:]...)
+	default:
+		grandchild := updated.children[0].clone()
+		grandchild.label = updated.label + grandchild.label
+		clone.children[idx] = grandchild
+	}
+	return clone, true
+}
+
+// WalkPath calls fn for each prefix of the path, from the shortest to the
+// longest, stopping early when fn returns false
+func (t *radixTree) WalkPath(path string, fn func(prefix string, value interface{}) bool) {
+	node := t.root
+	matched := 0
+
+	for {
+		if node.leaf && isSegmentBoundary(path, matched) {
+			if !fn(path[:matched], node.value) {
+				return
+			}
+		}
+
+		child, _ := node.child(path[matched:])
+		if child == nil || !strings.HasPrefix(path[matched:], child.label) {
+			return
+		}
+		node = child
+		matched += len(child.label)
+	}
+}
+
+// LongestPrefix returns the longest prefix of the path and its value
+func (t *radixTree) LongestPrefix(path string) (string, interface{}, bool) {
+	var prefix string
+	var value interface{}
+	found := false
+
+	t.WalkPath(path, func(p string, v interface{}) bool {
+		prefix, value, found = p, v, true
+		return true
+	})
+
+	return prefix, value, found
+}
+
+// isSegmentBoundary returns true when the first n bytes of the path end on a
+// path segment; either the whole path, or at a `/`
+func isSegmentBoundary(path string, n int) bool {
+	return n == len(path) || path[n] == '/' || (n > 0 && path[n-1] == '/')
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
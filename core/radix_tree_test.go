@@ -0,0 +1,96 @@
+package core
+
+import "testing"
+
+func TestRadixTreeLongestPrefix_matchesSegments(t *testing.T) {
+	tree := newRadixTree()
+	for _, prefix := range []string{"/api", "/api/v2/billing", "/api/v2", "/apis", "/admin"} {
+		tree = tree.Insert(prefix, prefix)
+	}
+
+	testCases := []struct {
+		path   string
+		prefix string
+		found  bool
+	}{
+		{"/api", "/api", true},
+		{"/api/", "/api", true},
+		{"/api/users", "/api", true},
+		{"/api/v2/billing/invoices", "/api/v2/billing", true},
+		{"/api/v2/billings", "/api/v2", true},
+		{"/api/v3", "/api", true},
+		{"/apis/x", "/apis", true},
+		{"/apiary", "", false},
+		{"/ad", "", false},
+		{"/", "", false},
+	}
+
+	for _, testCase := range testCases {
+		prefix, value, found := tree.LongestPrefix(testCase.path)
+		if found != testCase.found || prefix != testCase.prefix {
+			t.Fatalf("%s: expected %q, got %q", testCase.path, testCase.prefix, prefix)
+		}
+		if found && value.(string) != prefix {
+			t.Fatalf("%s: expected the value for %q, got %v", testCase.path, prefix, value)
+		}
+	}
+}
+
+func TestRadixTreeDelete_keepsOtherPrefixes(t *testing.T) {
+	tree := newRadixTree()
+	for _, prefix := range []string{"/", "/api", "/api/v2", "/apis"} {
+		tree = tree.Insert(prefix, prefix)
+	}
+
+	tree, deleted := tree.Delete("/api")
+	if !deleted {
+		t.Fatalf("expected /api to be deleted")
+	}
+	if _, deleted := tree.Delete("/api"); deleted {
+		t.Fatalf("expected /api to be missing")
+	}
+	if _, deleted := tree.Delete("/ap"); deleted {
+		t.Fatalf("expected /ap to be missing")
+	}
+
+	for path, expected := range map[string]string{
+		"/api/v2/x": "/api/v2",
+		"/api/v3":   "/",
+		"/apis":     "/apis",
+		"/other":    "/",
+	} {
+		if prefix, _, _ := tree.LongestPrefix(path); prefix != expected {
+			t.Fatalf("%s: expected %q, got %q", path, expected, prefix)
+		}
+	}
+
+	for _, prefix := range []string{"/", "/api/v2", "/apis"} {
+		tree, _ = tree.Delete(prefix)
+	}
+	if len(tree.root.children) != 0 {
+		t.Fatalf("expected an empty tree")
+	}
+}
+
+func TestRadixTreeInsert_leavesOriginalUnchanged(t *testing.T) {
+	original := newRadixTree().Insert("/api", "/api").Insert("/api/v2", "/api/v2")
+	updated := original.Insert("/api", "updated").Insert("/apis", "/apis")
+	updated, _ = updated.Delete("/api/v2")
+
+	if value, _ := original.Get("/api"); value.(string) != "/api" {
+		t.Fatalf("expected the original value, got %v", value)
+	}
+	if _, found := original.Get("/apis"); found {
+		t.Fatalf("expected /apis to only be in the updated tree")
+	}
+	if _, found := original.Get("/api/v2"); !found {
+		t.Fatalf("expected /api/v2 to remain in the original tree")
+	}
+
+	if value, _ := updated.Get("/api"); value.(string) != "updated" {
+		t.Fatalf("expected the updated value, got %v", value)
+	}
+	if prefix, _, _ := updated.LongestPrefix("/api/v2/x"); prefix != "/api" {
+		t.Fatalf("expected /api, got %q", prefix)
+	}
+}
@@ -1,46 +1,75 @@
 package core
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"time"
 )
 
 // RoundTripper is a timeout based http.RoundTripper client which passes the
-// response, duration and any raised errors to the responseHook.
+// response, duration and any raised errors to the responseHook. The timeout
+// cancels the request's context, so that the request to the backend is
+// canceled when it is exceeded, or when the client goes away. The timeout
+// only covers receiving the response headers; response bodies can take as
+// long as they need to be read.
 type roundTripper struct {
+	transport    http.RoundTripper
 	responseHook func(*http.Response, time.Duration, error) (*http.Response, error)
 	timeout      time.Duration
 }
 
-func NewRoundTripper(timeout time.Duration, responseHook func(*http.Response, time.Duration, error) (*http.Response, error)) http.RoundTripper {
+func NewRoundTripper(transport http.RoundTripper, timeout time.Duration, responseHook func(*http.Response, time.Duration, error) (*http.Response, error)) http.RoundTripper {
 	return &roundTripper{
+		transport:    transport,
 		timeout:      timeout,
 		responseHook: responseHook,
 	}
 }
 
 func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	doneCh := make(chan struct{})
-	cancelCh := make(chan struct{})
-
-	var err error
-	var resp *http.Response
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(r.timeout, cancel)
 
 	startTS := time.Now()
-	go func() {
-		resp, err = http.DefaultTransport.RoundTrip(req)
-		doneCh <- struct{}{}
-	}()
-
-	select {
-	case <-doneCh:
-		break
-		close(cancelCh)
-	case <-time.After(r.timeout):
-		err = ProxyTimeoutError
-		close(cancelCh)
+	resp, err := r.transport.RoundTrip(req.WithContext(ctx))
+	latency := time.Now().Sub(startTS)
+
+	timedOut := !timer.Stop()
+	if err != nil {
+		cancel()
+		return r.responseHook(nil, latency, roundTripError(req.Context(), timedOut, err))
 	}
 
-	latency := time.Now().Sub(startTS)
-	return r.responseHook(resp, latency, err)
+	// the headers arrived in time, so the timer is stopped and the context
+	// is only released once the body has been closed, which still cancels
+	// the request when the client goes away
+	resp.Body = &cancelOnCloseBody{
+		ReadCloser: resp.Body,
+		cancel:     cancel,
+	}
+	return r.responseHook(resp, latency, nil)
+}
+
+// roundTripError distinguishes between a request canceled by the client
+// disconnecting and one which exceeded its timeout
+func roundTripError(parentCtx context.Context, timedOut bool, err error) error {
+	if parentCtx.Err() != nil {
+		return ClientCanceledError
+	}
+	if timedOut {
+		return ProxyTimeoutError
+	}
+	return err
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (c *cancelOnCloseBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoundTripperRoundTrip_timeoutOnlyCoversHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow-headers" {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		rw.Write([]byte("first"))
+		rw.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		rw.Write([]byte(" second"))
+	}))
+	defer server.Close()
+
+	roundTripper := NewRoundTripper(http.DefaultTransport, 50*time.Millisecond, func(resp *http.Response, _ time.Duration, err error) (*http.Response, error) {
+		return resp, err
+	})
+
+	req, _ := http.NewRequest("GET", server.URL+"/slow-body", nil)
+	resp, err := roundTripper.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "first second" {
+		t.Fatalf("expected the whole body, got %q %v", body, err)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"/slow-headers", nil)
+	if _, err := roundTripper.RoundTrip(req); err != ProxyTimeoutError {
+		t.Fatalf("expected ProxyTimeoutError, got %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package core
+
+import (
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// routingTable indexes upstreams by their prefixes and hostnames. Tables are
+// immutable; withUpstream and withoutUpstream return a new table which shares
+// everything it can with the original, so that the local router can swap in
+// a new table as upstreams are added and removed while requests are routed
+// from the current one without locking.
+type routingTable struct {
+	// the entries claiming each path prefix, exact hostname and wildcard
+	// hostname suffix, in the order they were added, followed by each of
+	// the regex hostnames
+	prefixes       *radixTree
+	hostnames      *radixTree
+	wildcards      *radixTree
+	regexHostnames []*regexHostname
+
+	// the entries with Rules and neither prefixes nor hostnames
+	ruleEntries []*routeEntry
+}
+
+// routeEntry is an upstream along with its compiled Rules
+type routeEntry struct {
+	upstream *gatekeeper.Upstream
+	rules    []*routeRule
+}
+
+// regexHostname is a regex hostname and the entries which claim it
+type regexHostname struct {
+	regex   *regexp.Regexp
+	entries []*routeEntry
+}
+
+func newRoutingTable() *routingTable {
+	return &routingTable{
+		prefixes:  newRadixTree(),
+		hostnames: newRadixTree(),
+		wildcards: newRadixTree(),
+	}
+}
+
+// route matches a request to an upstream by prefix or hostname. The longest
+// prefix of the request's path which an upstream claims is matched first, with
+// shorter prefixes and then the request's hostname tried in turn. Hostnames
+// are matched in the order described by gatekeeper.ParseHostname, with the
+// groups captured by regex hostnames added to the request's Context.
+// Upstreams with Rules are only matched by requests which match one of them,
+// and take precedence over upstreams without Rules; those with neither
+// prefixes nor hostnames are tried last. Only upstreams which list the
+// request's protocol are matched; notExposed is true when the most specific
+// matching upstreams are not exposed on the request's protocol, in which case
+// less specific prefixes and hostnames are not tried, so that an upstream
+// which isn't exposed is never shadowed by a broader one which is.
+func (t *routingTable) route(req *gatekeeper.Request) (upstream *gatekeeper.Upstream, notExposed bool) {
+	var prefixes []string
+	var prefixEntries [][]*routeEntry
+	t.prefixes.WalkPath(req.Path, func(prefix string, value interface{}) bool {
+		prefixes = append(prefixes, prefix)
+		prefixEntries = append(prefixEntries, value.([]*routeEntry))
+		return true
+	})
+
+	for idx := len(prefixes) - 1; idx >= 0; idx-- {
+		upstream, ruleMatch, notExposed := matchEntries(prefixEntries[idx], req)
+		if notExposed {
+			return nil, true
+		}
+		if upstream == nil {
+			continue
+		}
+
+		req.SetPrefix(prefixes[idx])
+		req.UpstreamMatchType = upstreamMatchType(ruleMatch, gatekeeper.PrefixUpstreamMatch)
+		req.Path = req.PrefixlessPath
+		return upstream, false
+	}
+
+	upstream, captures, ruleMatch, notExposed := t.matchHostname(gatekeeper.NormalizeHostname(req.Host), req)
+	if notExposed {
+		return nil, true
+	}
+	if upstream != nil {
+		if len(captures) > 0 && req.Context == nil {
+			req.Context = make(map[string]string, len(captures))
+		}
+		for name, value := range captures {
+			req.Context[name] = value
+		}
+		req.UpstreamMatchType = upstreamMatchType(ruleMatch, gatekeeper.HostnameUpstreamMatch)
+		return upstream, false
+	}
+
+	upstream, _, notExposed = matchEntries(t.ruleEntries, req)
+	if upstream != nil {
+		req.UpstreamMatchType = gatekeeper.RuleUpstreamMatch
+		return upstream, false
+	}
+
+	return nil, notExposed
+}
+
+// matchHostname returns the upstream for the hostname, trying exact hostnames,
+// then wildcards from the longest suffix to the shortest, and then regex
+// hostnames, stopping at the first which matches but isn't exposed
+func (t *routingTable) matchHostname(hostname string, req *gatekeeper.Request) (*gatekeeper.Upstream, map[string]string, bool, bool) {
+	upstream, ruleMatch, notExposed := matchEntries(lookupEntries(t.hostnames, hostname), req)
+	if upstream != nil || notExposed {
+		return upstream, nil, ruleMatch, notExposed
+	}
+
+	for _, suffix := range gatekeeper.WildcardSuffixes(hostname) {
+		upstream, ruleMatch, notExposed := matchEntries(lookupEntries(t.wildcards, suffix), req)
+		if upstream != nil || notExposed {
+			return upstream, nil, ruleMatch, notExposed
+		}
+	}
+
+	for _, regexHostname := range t.regexHostnames {
+		ok, captures := gatekeeper.MatchHostnameRegex(regexHostname.regex, hostname)
+		if !ok {
+			continue
+		}
+
+		upstream, ruleMatch, notExposed := matchEntries(regexHostname.entries, req)
+		if upstream != nil || notExposed {
+			return upstream, captures, ruleMatch, notExposed
+		}
+	}
+
+	return nil, nil, false, false
+}
+
+// matchEntries returns the first of the entries' upstreams which is exposed on
+// the request's protocol and whose Rules, if it has any, match the request,
+// preferring upstreams with Rules over those without. When the first upstream
+// without Rules is in a traffic split, the upstream is instead chosen from the
+// split by weight, and the split recorded on the request. ruleMatch is true
+// when the upstream was matched by its Rules, and notExposed is true when there
+// was no upstream because the only matching ones aren't exposed on the
+// protocol.
+func matchEntries(entries []*routeEntry, req *gatekeeper.Request) (upstream *gatekeeper.Upstream, ruleMatch bool, notExposed bool) {
+	for _, entry := range entries {
+		if len(entry.rules) > 0 && !matchRules(entry.rules, req) {
+			continue
+		}
+
+		if !entry.upstream.HasProtocol(req.Protocol) {
+			notExposed = true
+			continue
+		}
+
+		if len(entry.rules) > 0 {
+			return entry.upstream, true, false
+		}
+		if upstream == nil {
+			upstream = entry.upstream
+		}
+	}
+
+	if upstream == nil {
+		return nil, false, notExposed
+	}
+
+	if upstream.Split != "" {
+		upstream = chooseSplitUpstream(entries, upstream, req.Protocol)
+		req.Split = upstream.Split
+	}
+	return upstream, false, false
+}
+
+// chooseSplitUpstream chooses one of the entries' upstreams without Rules in
+// the same traffic split as the given upstream, at random in proportion to
+// their weights. The given upstream is chosen when none have a weight.
+func chooseSplitUpstream(entries []*routeEntry, upstream *gatekeeper.Upstream, protocol gatekeeper.Protocol) *gatekeeper.Upstream {
+	inSplit := func(entry *routeEntry) bool {
+		return len(entry.rules) == 0 && entry.upstream.Split == upstream.Split && entry.upstream.HasProtocol(protocol)
+	}
+
+	var total uint
+	for _, entry := range entries {
+		if inSplit(entry) {
+			total += entry.upstream.Weight
+		}
+	}
+	if total == 0 {
+		return upstream
+	}
+
+	choice := uint(rand.Int63n(int64(total)))
+	for _, entry := range entries {
+		if !inSplit(entry) {
+			continue
+		}
+		if choice < entry.upstream.Weight {
+			return entry.upstream
+		}
+		choice -= entry.upstream.Weight
+	}
+
+	return upstream
+}
+
+// upstreamMatchType returns RuleUpstreamMatch for upstreams matched by their
+// Rules, and the given match type otherwise
+func upstreamMatchType(ruleMatch bool, matchType gatekeeper.UpstreamMatchType) gatekeeper.UpstreamMatchType {
+	if ruleMatch {
+		return gatekeeper.RuleUpstreamMatch
+	}
+	return matchType
+}
+
+// withUpstream returns a table with the upstream indexed by each of its
+// prefixes and hostnames
+func (t *routingTable) withUpstream(upstream *gatekeeper.Upstream) *routingTable {
+	// an upstream whose rules don't compile would otherwise match every
+	// request to its prefixes and hostnames, so it isn't routed to at all
+	rules, err := compileRules(upstream.Rules)
+	if err != nil {
+		log.Println(err)
+		return t
+	}
+
+	entry := &routeEntry{
+		upstream: upstream,
+		rules:    rules,
+	}
+	updated := *t
+
+	if len(rules) > 0 && len(upstream.Prefixes) == 0 && len(upstream.Hostnames) == 0 {
+		updated.ruleEntries = appendEntry(t.ruleEntries, entry)
+	}
+
+	for _, prefix := range upstream.Prefixes {
+		if key, ok := prefixKey(prefix); ok {
+			updated.prefixes = insertEntry(updated.prefixes, key, entry)
+		}
+	}
+
+	for _, hostname := range upstream.Hostnames {
+		typ, pattern, err := gatekeeper.ParseHostname(hostname)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		switch typ {
+		case gatekeeper.ExactHostname:
+			updated.hostnames = insertEntry(updated.hostnames, pattern, entry)
+		case gatekeeper.WildcardHostname:
+			updated.wildcards = insertEntry(updated.wildcards, pattern, entry)
+		case gatekeeper.RegexHostname:
+			regexHostnames, err := insertRegexHostname(updated.regexHostnames, pattern, entry)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			updated.regexHostnames = regexHostnames
+		}
+	}
+
+	return &updated
+}
+
+// withoutUpstream returns a table without the upstream, which must be the
+// version of the upstream that was indexed
+func (t *routingTable) withoutUpstream(upstream *gatekeeper.Upstream) *routingTable {
+	updated := *t
+	updated.ruleEntries = withoutEntry(t.ruleEntries, upstream.ID)
+
+	for _, prefix := range upstream.Prefixes {
+		if key, ok := prefixKey(prefix); ok {
+			updated.prefixes = deleteEntry(updated.prefixes, key, upstream.ID)
+		}
+	}
+
+	for _, hostname := range upstream.Hostnames {
+		typ, pattern, err := gatekeeper.ParseHostname(hostname)
+		if err != nil {
+			continue
+		}
+
+		switch typ {
+		case gatekeeper.ExactHostname:
+			updated.hostnames = deleteEntry(updated.hostnames, pattern, upstream.ID)
+		case gatekeeper.WildcardHostname:
+			updated.wildcards = deleteEntry(updated.wildcards, pattern, upstream.ID)
+		}
+	}
+
+	regexHostnames := make([]*regexHostname, 0, len(t.regexHostnames))
+	for _, claimed := range t.regexHostnames {
+		entries := withoutEntry(claimed.entries, upstream.ID)
+		switch {
+		case len(entries) == len(claimed.entries):
+			regexHostnames = append(regexHostnames, claimed)
+		case len(entries) > 0:
+			regexHostnames = append(regexHostnames, &regexHostname{
+				regex:   claimed.regex,
+				entries: entries,
+			})
+		default:
+			// nothing claims the expression any longer, so it isn't
+			// kept compiled; tables which still hold it have their own
+			// reference to the regex
+			gatekeeper.ReleaseHostnameRegex(claimed.regex.String())
+		}
+	}
+	updated.regexHostnames = regexHostnames
+
+	return &updated
+}
+
+// insertRegexHostname returns a copy of the regex hostnames with the entry
+// added to those claiming the expression, which is compiled and added after
+// the others when no entries claim it yet
+func insertRegexHostname(regexHostnames []*regexHostname, expr string, entry *routeEntry) ([]*regexHostname, error) {
+	inserted := make([]*regexHostname, len(regexHostnames), len(regexHostnames)+1)
+	copy(inserted, regexHostnames)
+
+	for idx, claimed := range inserted {
+		if claimed.regex.String() == expr {
+			inserted[idx] = &regexHostname{
+				regex:   claimed.regex,
+				entries: appendEntry(claimed.entries, entry),
+			}
+			return inserted, nil
+		}
+	}
+
+	regex, err := gatekeeper.CompileHostnameRegex(expr)
+	if err != nil {
+		return regexHostnames, err
+	}
+	return append(inserted, &regexHostname{
+		regex:   regex,
+		entries: []*routeEntry{entry},
+	}), nil
+}
+
+// lookupEntries returns the entries for an exact key of the tree
+func lookupEntries(tree *radixTree, key string) []*routeEntry {
+	value, ok := tree.Get(key)
+	if !ok {
+		return nil
+	}
+	return value.([]*routeEntry)
+}
+
+// insertEntry returns a tree with the entry added to those for the key
+func insertEntry(tree *radixTree, key string, entry *routeEntry) *radixTree {
+	return tree.Insert(key, appendEntry(lookupEntries(tree, key), entry))
+}
+
+// deleteEntry returns a tree with the upstream's entry removed from those for
+// the key, removing the key when no entries are left
+func deleteEntry(tree *radixTree, key string, upstreamID gatekeeper.UpstreamID) *radixTree {
+	entries := lookupEntries(tree, key)
+	if entries == nil {
+		return tree
+	}
+
+	entries = withoutEntry(entries, upstreamID)
+	if len(entries) == 0 {
+		tree, _ = tree.Delete(key)
+		return tree
+	}
+	return tree.Insert(key, entries)
+}
+
+// appendEntry returns a copy of the entries with the entry appended, leaving
+// the original, which older tables may still be reading, unchanged
+func appendEntry(entries []*routeEntry, entry *routeEntry) []*routeEntry {
+	appended := make([]*routeEntry, len(entries), len(entries)+1)
+	copy(appended, entries)
+	return append(appended, entry)
+}
+
+// withoutEntry returns a copy of the entries without the upstream's entry
+func withoutEntry(entries []*routeEntry, upstreamID gatekeeper.UpstreamID) []*routeEntry {
+	filtered := make([]*routeEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.upstream.ID != upstreamID {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// prefixKey returns the path prefix an upstream prefix claims, such as `/api`
+// for `api` or `/api/`, with `/` claiming every path. Empty prefixes claim
+// nothing.
+func prefixKey(prefix string) (string, bool) {
+	if prefix == "" {
+		return "", false
+	}
+	return "/" + strings.Trim(prefix, "/"), true
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/jonmorehouse/gatekeeper/gatekeeper"
 	router_plugin "github.com/jonmorehouse/gatekeeper/plugin/router"
@@ -17,29 +19,38 @@ type Router interface {
 	RouterClient
 }
 
+// RouteTable is a snapshot of the prefixes and hostnames a router is routing
+type RouteTable struct {
+	Prefixes  map[string][]gatekeeper.UpstreamID
+	Hostnames map[string][]gatekeeper.UpstreamID
+}
+
 func NewLocalRouter(broadcaster Broadcaster, metricWriter MetricWriter) Router {
-	return &localRouter{
-		broadcaster: broadcaster,
-		eventCh:     make(EventCh, 10),
+	router := &localRouter{
+		broadcaster:  broadcaster,
+		metricWriter: metricWriter,
+		eventCh:      make(EventCh, 10),
 
-		upstreams:     make(map[gatekeeper.UpstreamID]*gatekeeper.Upstream),
-		prefixCache:   make(map[string]*gatekeeper.Upstream),
-		hostnameCache: make(map[string]*gatekeeper.Upstream),
+		upstreams: make(map[gatekeeper.UpstreamID]*gatekeeper.Upstream),
 
 		Subscriber: NewSubscriber(broadcaster),
 	}
+	router.routes.Store(newRoutingTable())
+	return router
 }
 
 type localRouter struct {
-	broadcaster Broadcaster
-	listenerID  ListenerID
-	eventCh     EventCh
-
+	broadcaster  Broadcaster
+	metricWriter MetricWriterClient
+	listenerID   ListenerID
+	eventCh      EventCh
+
+	// the lock serializes changes to the upstreams, each of which stores a
+	// new routing table; requests are routed from the current table
+	// without taking the lock
 	RWMutex
-
-	upstreams     map[gatekeeper.UpstreamID]*gatekeeper.Upstream
-	prefixCache   map[string]*gatekeeper.Upstream
-	hostnameCache map[string]*gatekeeper.Upstream
+	upstreams map[gatekeeper.UpstreamID]*gatekeeper.Upstream
+	routes    atomic.Pointer[routingTable]
 
 	Subscriber
 }
@@ -50,60 +61,87 @@ func (l *localRouter) Start() error {
 	return l.Subscriber.Start()
 }
 
+// RouteRequest matches a request to an upstream using the current routing
+// table, as described by routingTable.route. When the only matching upstreams
+// are not exposed on the request's protocol, a RouteNotExposedError is
+// returned instead of a RouteNotFoundError.
 func (l *localRouter) RouteRequest(req *gatekeeper.Request) (*gatekeeper.Upstream, *gatekeeper.Request, error) {
-	l.RLock()
-	defer l.RUnlock()
-
-	upstream, hit := l.prefixCache[req.Prefix]
-	if hit {
-		req.Path = req.PrefixlessPath
+	upstream, notExposed := l.routes.Load().route(req)
+	if upstream != nil {
 		return upstream, req, nil
 	}
 
-	upstream, hit = l.hostnameCache[req.Host]
-	if hit {
-		return upstream, req, nil
+	if notExposed {
+		routeNotExposedMetric(l.metricWriter, "local-router", req)
+		return nil, req, RouteNotExposedError
 	}
 
-	log.Println(l.upstreams)
-	// check the upstream store for any and all matches
-	for _, upstream := range l.upstreams {
-		log.Println(upstream)
-		if InStrList(req.Host, upstream.Hostnames) {
-			l.hostnameCache[req.Host] = upstream
-			return upstream, req, nil
-		}
+	return nil, req, RouteNotFoundError
+}
+
+// routeNotExposedMetric writes a RouteNotExposedEvent for a request which was
+// routed to an upstream that isn't exposed on the request's protocol
+func routeNotExposedMetric(metricWriter MetricWriterClient, process string, req *gatekeeper.Request) {
+	metricWriter.EventMetric(&gatekeeper.EventMetric{
+		Timestamp: time.Now(),
+		Event:     gatekeeper.RouteNotExposedEvent,
+		Extra: map[string]string{
+			"process":  process,
+			"protocol": req.Protocol.String(),
+			"host":     req.Host,
+			"prefix":   req.Prefix,
+		},
+	})
+}
+
+// RouteTable returns the prefixes and hostnames of every upstream known to
+// the router, mapped to the upstreams which claim them
+func (l *localRouter) RouteTable() *RouteTable {
+	l.RLock()
+	defer l.RUnlock()
 
-		if InStrList(req.Prefix, upstream.Prefixes) {
-			l.prefixCache[req.Prefix] = upstream
-			req.Path = req.PrefixlessPath
-			return upstream, req, nil
+	table := &RouteTable{
+		Prefixes:  make(map[string][]gatekeeper.UpstreamID),
+		Hostnames: make(map[string][]gatekeeper.UpstreamID),
+	}
+
+	for upstreamID, upstream := range l.upstreams {
+		for _, prefix := range upstream.Prefixes {
+			table.Prefixes[prefix] = append(table.Prefixes[prefix], upstreamID)
+		}
+		for _, hostname := range upstream.Hostnames {
+			table.Hostnames[hostname] = append(table.Hostnames[hostname], upstreamID)
 		}
 	}
 
-	return nil, req, RouteNotFoundError
+	return table
 }
 
 func (l *localRouter) addUpstreamHook(event *UpstreamEvent) {
-	log.Println("add upstream...", event.Upstream)
 	l.Lock()
 	defer l.Unlock()
+
+	// an upstream being re-added may have changed its prefixes or
+	// hostnames, so it is removed from the table before being re-added
+	routes := l.routes.Load()
+	if existing, ok := l.upstreams[event.UpstreamID]; ok {
+		routes = routes.withoutUpstream(existing)
+	}
 	l.upstreams[event.UpstreamID] = event.Upstream
+	l.routes.Store(routes.withUpstream(event.Upstream))
 }
 
 func (l *localRouter) removeUpstreamHook(event *UpstreamEvent) {
-	log.Println("remove upstream")
 	l.Lock()
 	defer l.Unlock()
 
-	delete(l.upstreams, event.UpstreamID)
-	for _, host := range event.Upstream.Hostnames {
-		delete(l.hostnameCache, host)
+	upstream, ok := l.upstreams[event.UpstreamID]
+	if !ok {
+		return
 	}
 
-	for _, prefix := range event.Upstream.Prefixes {
-		delete(l.prefixCache, prefix)
-	}
+	delete(l.upstreams, event.UpstreamID)
+	l.routes.Store(l.routes.Load().withoutUpstream(upstream))
 }
 
 func NewPluginRouter(broadcaster Broadcaster, pluginManager PluginManager) Router {
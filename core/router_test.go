@@ -0,0 +1,329 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func newTestLocalRouter() *localRouter {
+	return NewLocalRouter(NewBroadcaster(), NewMetricWriter(1, 0)).(*localRouter)
+}
+
+func addTestUpstream(router *localRouter, upstream *gatekeeper.Upstream) {
+	if upstream.Protocols == nil {
+		upstream.Protocols = []gatekeeper.Protocol{gatekeeper.HTTPPublic}
+	}
+	router.addUpstreamHook(&UpstreamEvent{
+		Event:      gatekeeper.UpstreamAddedEvent,
+		Upstream:   upstream,
+		UpstreamID: upstream.ID,
+	})
+}
+
+func newTestRequest(host, path string) *gatekeeper.Request {
+	return &gatekeeper.Request{
+		Protocol: gatekeeper.HTTPPublic,
+		Method:   "GET",
+		Host:     host,
+		Path:     path,
+		Header:   http.Header{},
+	}
+}
+
+func TestLocalRouterRouteRequest_followsUpstreamChanges(t *testing.T) {
+	router := newTestLocalRouter()
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "api", Prefixes: []string{"api"}})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "web", Hostnames: []string{"*.example.com"}})
+
+	previous := router.routes.Load()
+
+	// re-adding an upstream replaces its prefixes rather than adding to them
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "api", Prefixes: []string{"api/v2"}})
+	router.removeUpstreamHook(&UpstreamEvent{Event: gatekeeper.UpstreamRemovedEvent, UpstreamID: "web"})
+
+	testCases := []struct {
+		host       string
+		path       string
+		upstreamID gatekeeper.UpstreamID
+	}{
+		{"www.example.com", "/api/v2/users", "api"},
+		{"www.example.com", "/api/users", ""},
+		{"www.example.com", "/", ""},
+	}
+
+	for _, testCase := range testCases {
+		upstream, _, err := router.RouteRequest(newTestRequest(testCase.host, testCase.path))
+		if testCase.upstreamID == "" {
+			if err != RouteNotFoundError {
+				t.Fatalf("%s%s: expected RouteNotFoundError, got %v", testCase.host, testCase.path, err)
+			}
+			continue
+		}
+		if err != nil || upstream.ID != testCase.upstreamID {
+			t.Fatalf("%s%s: expected %s, got %v %v", testCase.host, testCase.path, testCase.upstreamID, upstream, err)
+		}
+	}
+
+	// tables which requests may still be routing from are left unchanged
+	upstream, _ := previous.route(newTestRequest("www.example.com", "/api/users"))
+	if upstream == nil || upstream.ID != "api" {
+		t.Fatalf("expected the previous table to route to api, got %v", upstream)
+	}
+	upstream, _ = previous.route(newTestRequest("www.example.com", "/"))
+	if upstream == nil || upstream.ID != "web" {
+		t.Fatalf("expected the previous table to route to web, got %v", upstream)
+	}
+}
+
+func TestLocalRouterRouteRequest_releasesRegexHostnames(t *testing.T) {
+	router := newTestLocalRouter()
+	hostname := `~(?P<customer>[a-z]+)\.tenants\.example\.com`
+	_, expr, _ := gatekeeper.ParseHostname(hostname)
+
+	// an invalid expression, which an upstream plugin may send without it
+	// being validated, is skipped rather than indexed
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "acme", Hostnames: []string{hostname, "~(unclosed", "acme.example.com"}})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "globex", Hostnames: []string{hostname}})
+	if upstream, _, err := router.RouteRequest(newTestRequest("acme.example.com", "/")); err != nil || upstream.ID != "acme" {
+		t.Fatalf("expected acme, got %v %v", upstream, err)
+	}
+
+	regex := router.routes.Load().regexHostnames[0].regex
+	if cached, _ := gatekeeper.CompileHostnameRegex(expr); cached != regex || len(router.routes.Load().regexHostnames) != 1 {
+		t.Fatal("expected the table to use the cached regex")
+	}
+
+	// the regex stays cached until no upstream claims it
+	router.removeUpstreamHook(&UpstreamEvent{Event: gatekeeper.UpstreamRemovedEvent, UpstreamID: "acme"})
+	if cached, _ := gatekeeper.CompileHostnameRegex(expr); cached != regex {
+		t.Fatal("expected the regex to stay cached while globex claims it")
+	}
+	if upstream, req, err := router.RouteRequest(newTestRequest("initech.tenants.example.com", "/")); err != nil || upstream.ID != "globex" || req.Context["customer"] != "initech" {
+		t.Fatalf("expected globex, got %v %v", upstream, err)
+	}
+
+	router.removeUpstreamHook(&UpstreamEvent{Event: gatekeeper.UpstreamRemovedEvent, UpstreamID: "globex"})
+	if cached, _ := gatekeeper.CompileHostnameRegex(expr); cached == regex {
+		t.Fatal("expected the regex to be released once no upstream claims it")
+	}
+	gatekeeper.ReleaseHostnameRegex(expr)
+}
+
+// nextEventMetric returns the next EventMetric written for the event, skipping
+// over any other metrics
+func nextEventMetric(t *testing.T, metricWriter *metricWriter, event gatekeeper.Event) *gatekeeper.EventMetric {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case metric := <-metricWriter.bufferCh:
+			if metric, ok := metric.(*gatekeeper.EventMetric); ok && metric.Event == event {
+				return metric
+			}
+		case <-timeout:
+			t.Fatalf("expected a %s metric", event)
+			return nil
+		}
+	}
+}
+
+func TestLocalRouterRouteRequest_onlyRoutesExposedUpstreams(t *testing.T) {
+	public := []gatekeeper.Protocol{gatekeeper.HTTPPublic}
+	internal := []gatekeeper.Protocol{gatekeeper.HTTPInternal}
+
+	router := newTestLocalRouter()
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "api", Prefixes: []string{"api"}, Protocols: append(public, internal...)})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "admin", Prefixes: []string{"api/admin"}, Protocols: internal})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "web", Hostnames: []string{"*.example.com"}, Protocols: public})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "metrics", Hostnames: []string{"metrics.example.com"}, Protocols: internal})
+
+	testCases := []struct {
+		protocol   gatekeeper.Protocol
+		host       string
+		path       string
+		upstreamID gatekeeper.UpstreamID
+		err        error
+	}{
+		{gatekeeper.HTTPInternal, "www.example.com", "/api/admin/users", "admin", nil},
+		{gatekeeper.HTTPPublic, "www.example.com", "/api/users", "api", nil},
+		{gatekeeper.HTTPInternal, "metrics.example.com", "/", "metrics", nil},
+		{gatekeeper.HTTPPublic, "www.example.com", "/", "web", nil},
+		{gatekeeper.HTTPInternal, "example.org", "/", "", RouteNotFoundError},
+
+		// the most specific match isn't exposed, and broader matches
+		// which are aren't tried in its place
+		{gatekeeper.HTTPPublic, "www.example.com", "/api/admin/users", "", RouteNotExposedError},
+		{gatekeeper.HTTPPublic, "metrics.example.com", "/", "", RouteNotExposedError},
+	}
+
+	for _, testCase := range testCases {
+		req := newTestRequest(testCase.host, testCase.path)
+		req.Protocol = testCase.protocol
+
+		upstream, _, err := router.RouteRequest(req)
+		if err != testCase.err {
+			t.Fatalf("%s %s%s: expected %v, got %v", testCase.protocol, testCase.host, testCase.path, testCase.err, err)
+		}
+		if err == nil && upstream.ID != testCase.upstreamID {
+			t.Fatalf("%s %s%s: expected %s, got %s", testCase.protocol, testCase.host, testCase.path, testCase.upstreamID, upstream.ID)
+		}
+
+		if err == RouteNotExposedError {
+			metric := nextEventMetric(t, router.metricWriter.(*metricWriter), gatekeeper.RouteNotExposedEvent)
+			if metric.Extra["protocol"] != testCase.protocol.String() || metric.Extra["host"] != testCase.host {
+				t.Fatalf("%s %s%s: unexpected metric %v", testCase.protocol, testCase.host, testCase.path, metric.Extra)
+			}
+		}
+	}
+}
+
+func TestLocalRouterRouteRequest_splitsByWeight(t *testing.T) {
+	router := newTestLocalRouter()
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "billing-v1", Prefixes: []string{"billing"}, Split: "billing", Weight: 3})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "billing-v2", Prefixes: []string{"billing"}, Split: "billing", Weight: 1})
+	addTestUpstream(router, &gatekeeper.Upstream{ID: "billing-v3", Prefixes: []string{"billing"}, Split: "billing"})
+
+	routed := make(map[gatekeeper.UpstreamID]int)
+	for idx := 0; idx < 4000; idx++ {
+		upstream, req, err := router.RouteRequest(newTestRequest("example.com", "/billing/invoices"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Split != "billing" {
+			t.Fatalf("expected the billing split, got %q", req.Split)
+		}
+		routed[upstream.ID]++
+	}
+
+	if routed["billing-v3"] != 0 {
+		t.Fatalf("expected no requests to the zero weight upstream, got %d", routed["billing-v3"])
+	}
+	if routed["billing-v2"] < 800 || routed["billing-v2"] > 1200 {
+		t.Fatalf("expected about a quarter of requests to billing-v2, got %v", routed)
+	}
+}
+
+const benchmarkUpstreams = 10000
+
+// newBenchmarkRouter returns a router with upstreams claiming a prefix, an
+// exact hostname and a wildcard hostname each
+func newBenchmarkRouter(b *testing.B) *localRouter {
+	// RWMutex logs each lock, which would otherwise drown out the results
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	router := newTestLocalRouter()
+	for idx := 0; idx < benchmarkUpstreams; idx++ {
+		addTestUpstream(router, &gatekeeper.Upstream{
+			ID:       gatekeeper.UpstreamID(fmt.Sprintf("upstream-%d", idx)),
+			Prefixes: []string{fmt.Sprintf("service-%d/v%d", idx, idx%3)},
+			Hostnames: []string{
+				fmt.Sprintf("service-%d.internal", idx),
+				fmt.Sprintf("*.tenant-%d.example.com", idx),
+			},
+		})
+	}
+	b.ResetTimer()
+	return router
+}
+
+func benchmarkRouteRequest(b *testing.B, router *localRouter, host, path string) {
+	b.ReportAllocs()
+	for idx := 0; idx < b.N; idx++ {
+		if _, _, err := router.RouteRequest(newTestRequest(host, path)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalRouterRouteRequest_prefix(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	benchmarkRouteRequest(b, router, "unknown.example.org", "/service-5000/v2/users/1")
+}
+
+func BenchmarkLocalRouterRouteRequest_hostname(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	benchmarkRouteRequest(b, router, "service-5000.internal", "/users/1")
+}
+
+func BenchmarkLocalRouterRouteRequest_wildcard(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	benchmarkRouteRequest(b, router, "eu.acme.tenant-5000.example.com", "/users/1")
+}
+
+func BenchmarkLocalRouterRouteRequest_notFound(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	b.ReportAllocs()
+	for idx := 0; idx < b.N; idx++ {
+		if _, _, err := router.RouteRequest(newTestRequest("unknown.example.org", "/users/1")); err != RouteNotFoundError {
+			b.Fatalf("expected RouteNotFoundError, got %v", err)
+		}
+	}
+}
+
+func BenchmarkLocalRouterRouteRequest_parallel(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		idx := 0
+		for pb.Next() {
+			path := fmt.Sprintf("/service-%d/v%d/users", idx%benchmarkUpstreams, (idx%benchmarkUpstreams)%3)
+			if _, _, err := router.RouteRequest(newTestRequest("unknown.example.org", path)); err != nil {
+				b.Fatal(err)
+			}
+			idx++
+		}
+	})
+}
+
+// BenchmarkLocalRouterRouteRequest_parallelWithUpdates routes requests while
+// an upstream is repeatedly re-added, which swaps in a new routing table each
+// time
+func BenchmarkLocalRouterRouteRequest_parallelWithUpdates(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				addTestUpstream(router, &gatekeeper.Upstream{ID: "updated", Prefixes: []string{"updated"}})
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := router.RouteRequest(newTestRequest("unknown.example.org", "/service-5000/v2/users")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkLocalRouterAddUpstream(b *testing.B) {
+	router := newBenchmarkRouter(b)
+	b.ReportAllocs()
+	for idx := 0; idx < b.N; idx++ {
+		addTestUpstream(router, &gatekeeper.Upstream{
+			ID:        "updated",
+			Prefixes:  []string{fmt.Sprintf("updated-%d", idx)},
+			Hostnames: []string{"updated.internal"},
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// routeRule is a gatekeeper.Rule with its regular expressions compiled, so
+// that it can be evaluated for each request
+type routeRule struct {
+	methods []string
+	headers []*fieldRule
+	query   []*fieldRule
+	cookies []*fieldRule
+}
+
+type fieldRule struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// compileRules compiles an upstream's Rules, returning an error for rules
+// with an unnamed field or an invalid regular expression
+func compileRules(rules []gatekeeper.Rule) ([]*routeRule, error) {
+	compiled := make([]*routeRule, len(rules))
+	for idx, rule := range rules {
+		methods := make([]string, len(rule.Methods))
+		for methodIdx, method := range rule.Methods {
+			methods[methodIdx] = strings.ToUpper(method)
+		}
+
+		headers, err := compileFieldRules(rule.Headers)
+		if err != nil {
+			return nil, err
+		}
+		query, err := compileFieldRules(rule.Query)
+		if err != nil {
+			return nil, err
+		}
+		cookies, err := compileFieldRules(rule.Cookies)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled[idx] = &routeRule{
+			methods: methods,
+			headers: headers,
+			query:   query,
+			cookies: cookies,
+		}
+	}
+
+	return compiled, nil
+}
+
+func compileFieldRules(rules []gatekeeper.FieldRule) ([]*fieldRule, error) {
+	compiled := make([]*fieldRule, len(rules))
+	for idx, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%w: field name required", gatekeeper.InvalidRuleErr)
+		}
+
+		compiled[idx] = &fieldRule{
+			name:  rule.Name,
+			value: rule.Value,
+		}
+
+		if rule.Regex == "" {
+			continue
+		}
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", gatekeeper.InvalidRuleErr, rule.Name, err)
+		}
+		compiled[idx].regex = regex
+	}
+
+	return compiled, nil
+}
+
+// matchRules returns true when any of the rules match the request
+func matchRules(rules []*routeRule, req *gatekeeper.Request) bool {
+	for _, rule := range rules {
+		if rule.matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *routeRule) matches(req *gatekeeper.Request) bool {
+	if len(r.methods) > 0 && !InStrList(req.Method, r.methods) {
+		return false
+	}
+
+	for _, header := range r.headers {
+		if !header.matches(req.Header.Values(header.name)) {
+			return false
+		}
+	}
+
+	if len(r.query) > 0 {
+		query, _ := url.ParseQuery(req.RawQuery)
+		for _, param := range r.query {
+			if !param.matches(query[param.name]) {
+				return false
+			}
+		}
+	}
+
+	if len(r.cookies) > 0 {
+		cookies := (&http.Request{Header: req.Header}).Cookies()
+		for _, cookie := range r.cookies {
+			if !cookie.matches(cookieValues(cookies, cookie.name)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matches returns true when the field is present and, when the rule has a
+// value or a regular expression, one of the field's values satisfies them
+func (f *fieldRule) matches(values []string) bool {
+	for _, value := range values {
+		if f.value != "" && value != f.value {
+			continue
+		}
+		if f.regex != nil && !f.regex.MatchString(value) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func cookieValues(cookies []*http.Cookie, name string) []string {
+	var values []string
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			values = append(values, cookie.Value)
+		}
+	}
+	return values
+}
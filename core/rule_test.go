@@ -0,0 +1,58 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestMatchRules_matchesPredicates(t *testing.T) {
+	rules, err := compileRules([]gatekeeper.Rule{
+		{
+			Methods: []string{"get", "HEAD"},
+			Headers: []gatekeeper.FieldRule{{Name: "x-api-version", Value: "2"}},
+		},
+		{
+			Query:   []gatekeeper.FieldRule{{Name: "debug"}, {Name: "region", Regex: "^eu-"}},
+			Cookies: []gatekeeper.FieldRule{{Name: "beta", Value: "true"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method   string
+		header   http.Header
+		rawQuery string
+		match    bool
+	}{
+		{"GET", http.Header{"X-Api-Version": {"2"}}, "", true},
+		{"POST", http.Header{"X-Api-Version": {"2"}}, "", false},
+		{"GET", http.Header{"X-Api-Version": {"1"}}, "", false},
+		{"GET", http.Header{}, "", false},
+		{"POST", http.Header{"Cookie": {"beta=true; other=1"}}, "debug&region=eu-west", true},
+		{"POST", http.Header{"Cookie": {"beta=true"}}, "region=eu-west", false},
+		{"POST", http.Header{"Cookie": {"beta=true"}}, "debug&region=us-east", false},
+		{"POST", http.Header{"Cookie": {"beta=false"}}, "debug&region=eu-west", false},
+	}
+
+	for idx, testCase := range testCases {
+		req := &gatekeeper.Request{Method: testCase.method, Header: testCase.header, RawQuery: testCase.rawQuery}
+		if matchRules(rules, req) != testCase.match {
+			t.Fatalf("case %d: expected match to be %t", idx, testCase.match)
+		}
+	}
+}
+
+func TestCompileRules_rejectsInvalidRules(t *testing.T) {
+	for _, rule := range []gatekeeper.Rule{
+		{Headers: []gatekeeper.FieldRule{{Value: "2"}}},
+		{Query: []gatekeeper.FieldRule{{Name: "region", Regex: "(eu"}}},
+	} {
+		if _, err := compileRules([]gatekeeper.Rule{rule}); err == nil {
+			t.Fatalf("expected %+v to be invalid", rule)
+		}
+	}
+}
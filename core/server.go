@@ -1,10 +1,14 @@
 package core
 
 import (
-	"fmt"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jonmorehouse/gatekeeper/gatekeeper"
@@ -14,42 +18,53 @@ import (
 type Server interface {
 	starter
 	gracefulStopper
-}
 
-func NewHTTPServer(protocol gatekeeper.Protocol, port uint, router RouterClient, lb LoadBalancerClient, modifier ModifierClient, proxier Proxier, metricWriter MetricWriterClient) Server {
-	mux := http.NewServeMux()
+	// Drain stops the server from accepting new connections and requests
+	// and waits, up to the given duration, for any in-flight requests to
+	// finish. Requests arriving on open connections while draining are
+	// answered with a ServerShuttingDownError.
+	Drain(time.Duration) error
 
-	instance := &server{
-		protocol: protocol,
-		port:     port,
+	// InFlight returns the number of requests currently being handled
+	InFlight() int64
 
-		router:       router,
-		loadBalancer: lb,
-		modifier:     modifier,
-		metricWriter: metricWriter,
-		proxier:      proxier,
+	ListenerConfig() ListenerConfig
 
-		stopCh: make(chan struct{}, 1),
-		errCh:  make(chan error, 1),
+	// ListenerFile returns a duplicate of the server's listening socket, so
+	// that it can be passed to a new process during an upgrade
+	ListenerFile() (*os.File, error)
 
-		httpServer: &graceful.Server{
-			Server: &http.Server{
-				Addr:    fmt.Sprintf(":%d", port),
-				Handler: mux,
-			},
-			NoSignalHandling: true,
-		},
-	}
-	mux.HandleFunc("/", instance.httpHandler)
-	return instance
+	// ListenerHandedOff is called once the new process of an upgrade has
+	// taken over the server's listening socket
+	ListenerHandedOff()
+
+	// Handle registers an additional handler on the server, for requests
+	// matching pattern, which takes precedence over proxying. It must be
+	// called before the server is started.
+	Handle(pattern string, handler http.Handler)
 }
 
-func NewHTTPSServer(protocol gatekeeper.Protocol, port uint, router RouterClient, lb LoadBalancerClient, modifier ModifierClient, proxier Proxier, metricWriter MetricWriterClient) Server {
-	mux := http.NewServeMux()
+// NewHTTPServer returns a Server which serves cleartext HTTP/1.1 and, when h2c
+// is true, HTTP/2 from clients with prior knowledge.
+func NewHTTPServer(listener ListenerConfig, h2c bool, router RouterClient, lb LoadBalancerClient, modifier ModifierClient, proxier Proxier, metricWriter MetricWriterClient) Server {
+	return newServer(listener, nil, nil, h2c, router, lb, modifier, proxier, metricWriter)
+}
+
+// NewHTTPSServer returns a Server which terminates TLS on its listener, using
+// the certificates and settings in tlsConfig. HTTP/2 is served to clients
+// when it is one of the tlsConfig's NextProtos. Client certificates are
+// verified against the CertificateStore's client CA bundle.
+func NewHTTPSServer(listener ListenerConfig, tlsConfig *tls.Config, certificates CertificateStore, router RouterClient, lb LoadBalancerClient, modifier ModifierClient, proxier Proxier, metricWriter MetricWriterClient) Server {
+	return newServer(listener, tlsConfig, certificates, false, router, lb, modifier, proxier, metricWriter)
+}
 
-	instance := &server{
-		protocol: protocol,
-		port:     port,
+func newServer(listener ListenerConfig, tlsConfig *tls.Config, certificates CertificateStore, h2c bool, router RouterClient, lb LoadBalancerClient, modifier ModifierClient, proxier Proxier, metricWriter MetricWriterClient) Server {
+	return &server{
+		protocol:       listener.Protocol,
+		listenerConfig: listener,
+		tlsConfig:      tlsConfig,
+		certificates:   certificates,
+		h2c:            h2c,
 
 		router:       router,
 		loadBalancer: lb,
@@ -57,25 +72,20 @@ func NewHTTPSServer(protocol gatekeeper.Protocol, port uint, router RouterClient
 		metricWriter: metricWriter,
 		proxier:      proxier,
 
-		stopCh: make(chan struct{}, 1),
-		errCh:  make(chan error, 1),
+		stopCh:   make(chan struct{}, 1),
+		errCh:    make(chan error, 1),
+		handlers: make(map[string]http.Handler),
 
-		httpServer: &graceful.Server{
-			Server: &http.Server{
-				Addr:    fmt.Sprintf(":%d", port),
-				Handler: mux,
-			},
-			NoSignalHandling: true,
-		},
+		SyncStartStopper: &syncStartStopper{},
 	}
-
-	mux.HandleFunc("/", instance.httpHandler)
-	return instance
 }
 
 type server struct {
-	protocol gatekeeper.Protocol
-	port     uint
+	protocol       gatekeeper.Protocol
+	listenerConfig ListenerConfig
+	tlsConfig      *tls.Config
+	certificates   CertificateStore
+	h2c            bool
 
 	router       RouterClient
 	loadBalancer LoadBalancerClient
@@ -87,7 +97,17 @@ type server struct {
 	stopCh        chan struct{}
 	errCh         chan error
 
+	// track in-flight requests so that the server can be drained
+	inFlight   int64
+	inFlightWg sync.WaitGroup
+
+	// the listener is closed only once, by whichever of Drain or Stop is
+	// called first
+	closeOnce sync.Once
+
 	httpServer *graceful.Server
+	listener   net.Listener
+	handlers   map[string]http.Handler
 
 	SyncStartStopper
 	sync.Mutex
@@ -104,19 +124,104 @@ func (s *server) Start() error {
 func (s *server) Stop(duration time.Duration) error {
 	return s.SyncStop(func() error {
 		s.eventMetric(gatekeeper.ServerStoppedEvent)
-		s.httpServer.Stop(duration)
+		s.closeListener(duration)
 		s.stopCh <- struct{}{}
 		return <-s.errCh
 	})
 }
 
+func (s *server) Drain(timeout time.Duration) error {
+	s.Lock()
+	s.stopAccepting = true
+	s.Unlock()
+
+	s.eventMetric(gatekeeper.ServerDrainingEvent)
+	if s.Started() {
+		s.closeListener(timeout)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.inFlightWg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		return nil
+	case <-time.After(timeout):
+		return DrainTimeoutError
+	}
+}
+
+// closeListener stops the underlying graceful.Server from accepting new
+// connections, giving open connections up to timeout to finish.
+func (s *server) closeListener(timeout time.Duration) {
+	s.closeOnce.Do(func() {
+		s.httpServer.Stop(timeout)
+	})
+}
+
+func (s *server) ListenerConfig() ListenerConfig {
+	return s.listenerConfig
+}
+
+func (s *server) ListenerFile() (*os.File, error) {
+	if !s.Started() {
+		return nil, ServerNotStartedError
+	}
+
+	return listenerFile(s.listener)
+}
+
+func (s *server) ListenerHandedOff() {
+	keepSocketPath(s.listener)
+}
+
+func (s *server) Handle(pattern string, handler http.Handler) {
+	s.handlers[pattern] = handler
+}
+
+func (s *server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// acceptRequest marks a request as in-flight, returning false if the server
+// is draining and should no longer accept requests. The lock ensures that no
+// request is added to the in-flight WaitGroup once Drain has begun waiting.
+func (s *server) acceptRequest() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stopAccepting {
+		return false
+	}
+
+	s.inFlightWg.Add(1)
+	atomic.AddInt64(&s.inFlight, 1)
+	return true
+}
+
+func (s *server) finishRequest() {
+	atomic.AddInt64(&s.inFlight, -1)
+	s.inFlightWg.Done()
+}
+
 func (s *server) startHTTP() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.httpHandler)
+	for pattern, handler := range s.handlers {
+		mux.Handle(pattern, handler)
+	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: mux,
+		Addr:      s.listenerConfig.Address,
+		Handler:   mux,
+		TLSConfig: s.tlsConfig,
+		Protocols: s.protocols(),
+	}
+	if s.tlsConfig != nil {
+		server.ConnContext = withConnClientCertificate
 	}
 
 	s.httpServer = &graceful.Server{
@@ -124,13 +229,29 @@ func (s *server) startHTTP() error {
 		NoSignalHandling: true,
 	}
 
+	// bind the listener up front so that errors such as the port being in
+	// use are returned directly, wrapping it to read PROXY headers, which
+	// precede the TLS handshake, and to terminate TLS when this is an https
+	// server.
+	listener, err := listen(s.listenerConfig)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	if s.listenerConfig.ProxyProtocol != nil {
+		listener = newProxyProtocolListener(listener, s.listenerConfig.ProxyProtocol)
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
 	// the errCh is responsible for emitting an error when the server fails or closes.
 	errCh := make(chan error, 1)
 
 	// start the server in a goroutine, passing any errors back to the errCh
 	go func() {
-		log.Println("listening on port: ", s.port)
-		err := s.httpServer.ListenAndServe()
+		log.Println("listening on: ", s.listenerConfig)
+		err := s.httpServer.Serve(listener)
 		errCh <- err
 	}()
 
@@ -148,13 +269,42 @@ finished:
 	return nil
 }
 
+// protocols returns the HTTP versions the server accepts; HTTP/2 is served
+// over TLS when negotiated with ALPN, and over cleartext only when h2c is
+// enabled.
+func (s *server) protocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+
+	if s.tlsConfig != nil {
+		protocols.SetHTTP2(slices.Contains(s.tlsConfig.NextProtos, "h2"))
+	} else {
+		protocols.SetUnencryptedHTTP2(s.h2c)
+	}
+
+	return protocols
+}
+
 func (s *server) httpHandler(rw http.ResponseWriter, rawReq *http.Request) {
 	start := time.Now()
 	req := gatekeeper.NewRequest(rawReq, s.protocol)
 
+	// a client certificate is verified, once per connection, before
+	// routing so that router plugins are able to use it, but it is only
+	// acted upon once the upstream's ClientAuth policy is known
+	clientCert, clientCertErr := connVerifyClientCertificate(rawReq, s.certificates)
+	req.ClientCertificate = clientCert
+
+	// the forwarding headers are resolved before the request is passed to
+	// any plugins, so that they see the same headers as the backend
+	trustedProxies := s.listenerConfig.TrustedProxies
+	req.ClientIP = resolveClientIP(rawReq.RemoteAddr, req.Header, trustedProxies)
+	setForwardedHeaders(req.Header, rawReq.RemoteAddr, req.Host, s.protocol, trustedProxies)
+
 	metric := &gatekeeper.RequestMetric{
 		Request:        req,
 		RequestStartTS: start,
+		Proto:          rawReq.Proto,
 	}
 
 	s.eventMetric(gatekeeper.RequestAcceptedEvent)
@@ -172,36 +322,55 @@ func (s *server) httpHandler(rw http.ResponseWriter, rawReq *http.Request) {
 		s.metricWriter.RequestMetric(metric)
 	}(metric)
 
-	if s.stopAccepting {
-		resp := gatekeeper.NewErrorResponse(500, ServerShuttingDownError)
-		metric.Response = resp
+	if !s.acceptRequest() {
+		resp := gatekeeper.NewErrorResponse(503, ServerShuttingDownError)
 		metric.Error = gatekeeper.NewError(ServerShuttingDownError)
-		s.writeError(rw, ServerShuttingDownError, req, resp)
+		rw.Header().Set("Connection", "close")
+		s.writeError(rw, ServerShuttingDownError, req, resp, metric)
 		return
 	}
+	defer s.finishRequest()
 
 	// build a *gatekeeper.Request for this rawReq; a wrapper with additional
 	// meta information around an *http.Request object
 	matchStartTS := time.Now()
 	upstream, req, err := s.router.RouteRequest(req)
+
+	// routers are trusted to route within the request's protocol, but
+	// whichever router is in use, an upstream is never proxied to from a
+	// protocol it isn't exposed on
+	if err == nil && !upstream.HasProtocol(req.Protocol) {
+		routeNotExposedMetric(s.metricWriter, "server", req)
+		err = RouteNotExposedError
+	}
 	if err != nil {
 		resp := gatekeeper.NewErrorResponse(400, err)
-		metric.Response = resp
 		metric.Error = gatekeeper.NewError(err)
-		s.writeError(rw, err, req, resp)
+		s.writeError(rw, err, req, resp, metric)
 		return
 	}
 	metric.RouterLatency = time.Now().Sub(matchStartTS)
 	metric.Upstream = upstream
+	metric.Split = req.Split
+
+	if err := checkClientAuth(upstream.ClientAuth, clientCert, clientCertErr); err != nil {
+		statusCode := 401
+		if err == InvalidClientCertificateError {
+			statusCode = 403
+		}
+		resp := gatekeeper.NewErrorResponse(statusCode, err)
+		metric.Error = gatekeeper.NewError(err)
+		s.writeError(rw, err, req, resp, metric)
+		return
+	}
 
 	// fetch a backend from the loadbalancer to proxy this request too
 	loadBalancerStartTS := time.Now()
 	backend, err := s.loadBalancer.GetBackend(upstream.ID)
 	if err != nil {
 		resp := gatekeeper.NewErrorResponse(500, err)
-		metric.Response = resp
 		metric.Error = gatekeeper.NewError(err)
-		s.writeError(rw, err, req, resp)
+		s.writeError(rw, err, req, resp, metric)
 		return
 	}
 	metric.LoadBalancerLatency = time.Now().Sub(loadBalancerStartTS)
@@ -213,17 +382,15 @@ func (s *server) httpHandler(rw http.ResponseWriter, rawReq *http.Request) {
 		log.Println(err)
 		resp := gatekeeper.NewErrorResponse(500, err)
 		metric.Error = gatekeeper.NewError(err)
-		metric.Response = resp
-		s.writeError(rw, err, req, resp)
+		s.writeError(rw, err, req, resp, metric)
 		return
 	}
 	metric.RequestModifierLatency = time.Now().Sub(modifierStartTS)
 
 	if req.Error != nil {
-		resp := gatekeeper.NewErrorResponse(500, err)
+		resp := gatekeeper.NewErrorResponse(500, req.Error)
 		metric.Error = req.Error
-		metric.Response = resp
-		s.writeError(rw, err, req, resp)
+		s.writeError(rw, req.Error, req, resp, metric)
 		return
 	}
 
@@ -238,38 +405,61 @@ func (s *server) httpHandler(rw http.ResponseWriter, rawReq *http.Request) {
 	// proxy error in the proxy lifecycle is handled internally, due to the
 	// coupling that is required with the internal go httputil.ReverseProxy
 	// and http.Transport types
-	if err := s.proxier.Proxy(rw, rawReq, req, upstream, backend, metric); err != nil {
+	proxy := s.proxier.Proxy
+	if isUpgradeRequest(rawReq) {
+		proxy = s.proxier.ProxyUpgrade
+	}
+	if err := proxy(rw, rawReq, req, upstream, backend, metric); err != nil {
 		resp := gatekeeper.NewErrorResponse(500, err)
-		metric.Response = resp
 		metric.Error = gatekeeper.NewError(err)
-		s.writeError(rw, err, req, resp)
+		s.writeError(rw, err, req, resp, metric)
 		return
 	}
 
 	s.eventMetric(gatekeeper.RequestSuccessEvent)
 }
 
-// write an error response, calling the ErrorResponse handler in the modifier plugin
-func (s *server) writeError(rw http.ResponseWriter, err error, request *gatekeeper.Request, response *gatekeeper.Response) {
-	response, err = s.modifier.ModifyErrorResponse(err, request, response)
-	if err != nil {
+// write an error response, calling the ErrorResponse handler in the modifier
+// plugin. Errors for gRPC requests are written as gRPC statuses, which gRPC
+// clients are able to parse.
+func (s *server) writeError(rw http.ResponseWriter, err error, request *gatekeeper.Request, response *gatekeeper.Response, metric *gatekeeper.RequestMetric) {
+	response, modifierErr := s.modifier.ModifyErrorResponse(err, request, response)
+	if modifierErr != nil {
 		response.Body = []byte(ModifierPluginError.Error())
 		response.StatusCode = 500
 	}
 
+	if isGRPCRequest(request.Header) {
+		response = grpcErrorResponse(err, response)
+		recordGRPCStatus(metric, response.Header, nil)
+	}
+
+	metric.Response = response
 	s.eventMetric(gatekeeper.RequestErrorEvent)
 	s.writeResponse(rw, response)
 }
 
-// write a *gatekeeper.Response to an http.ResponseWriter
 func (s *server) writeResponse(rw http.ResponseWriter, response *gatekeeper.Response) {
-	rw.WriteHeader(response.StatusCode)
+	writeResponse(rw, response)
+}
 
+// write a *gatekeeper.Response to an http.ResponseWriter. Headers must be set
+// before the status code is written, otherwise they are never sent, and
+// trailers are sent once the body has been written.
+func writeResponse(rw http.ResponseWriter, response *gatekeeper.Response) {
 	for header, values := range response.Header {
 		for _, value := range values {
-			rw.Header().Set(header, value)
+			rw.Header().Add(header, value)
 		}
 	}
+	rw.WriteHeader(response.StatusCode)
+	defer func() {
+		for trailer, values := range response.Trailer {
+			for _, value := range values {
+				rw.Header().Add(http.TrailerPrefix+trailer, value)
+			}
+		}
+	}()
 
 	// TODO: add metrics around this error to see where it happens in
 	// practice; adding robustness once error edges have shown
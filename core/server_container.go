@@ -1,38 +1,52 @@
 package core
 
-import "github.com/jonmorehouse/gatekeeper/gatekeeper"
+import (
+	"crypto/tls"
+	"sync"
 
-type ServerContainer map[gatekeeper.Protocol]Server
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
 
-func buildServers(options Options, router Router, loadBalancer LoadBalancer, modifier Modifier, proxier Proxier, metricWriter MetricWriter) ServerContainer {
-	servers := make(ServerContainer)
+type ServerContainer map[gatekeeper.Protocol][]Server
 
-	pairings := [][2]interface{}{
-		[2]interface{}{gatekeeper.HTTPPublic, options.HTTPPublicPort},
-		[2]interface{}{gatekeeper.HTTPInternal, options.HTTPInternalPort},
-		[2]interface{}{gatekeeper.HTTPSPublic, options.HTTPSPublicPort},
-		[2]interface{}{gatekeeper.HTTPSInternal, options.HTTPSInternalPort},
+// buildServers builds a Server for each of the configured listeners. https
+// servers share a single tls.Config, backed by the CertificateStore, apart
+// from the https-internal servers, which also request client certificates when
+// a client CA bundle is configured.
+func buildServers(options Options, certificates CertificateStore, router Router, loadBalancer LoadBalancer, modifier Modifier, proxier Proxier, metricWriter MetricWriter) (ServerContainer, error) {
+	if len(options.Listeners) == 0 {
+		return nil, NoListenersError
 	}
 
-	for _, pairing := range pairings {
-		prot := pairing[0].(gatekeeper.Protocol)
-		method := NewHTTPServer
-		if prot == gatekeeper.HTTPSPublic || prot == gatekeeper.HTTPSInternal {
-			method = NewHTTPServer
+	servers := make(ServerContainer)
+	var tlsConfig, internalTLSConfig *tls.Config
+
+	for _, listener := range options.Listeners {
+		if !listener.Protocol.IsTLS() {
+			servers[listener.Protocol] = append(servers[listener.Protocol], NewHTTPServer(listener, options.H2C, router, loadBalancer, modifier, proxier, metricWriter))
+			continue
 		}
 
-		servers[prot] = method(
-			prot,
-			pairing[1].(uint),
-			router,
-			loadBalancer,
-			modifier,
-			proxier,
-			metricWriter,
-		)
+		if certificates == nil {
+			return nil, TLSCertificateRequiredError
+		}
+
+		if tlsConfig == nil {
+			tlsConfig = buildTLSConfig(options, certificates)
+		}
+
+		serverTLSConfig := tlsConfig
+		if listener.Protocol == gatekeeper.HTTPSInternal && options.TLSClientCAFile != "" {
+			if internalTLSConfig == nil {
+				internalTLSConfig = buildClientAuthTLSConfig(tlsConfig, certificates)
+			}
+			serverTLSConfig = internalTLSConfig
+		}
+
+		servers[listener.Protocol] = append(servers[listener.Protocol], NewHTTPSServer(listener, serverTLSConfig, certificates, router, loadBalancer, modifier, proxier, metricWriter))
 	}
 
-	return servers
+	return servers, nil
 }
 
 func filterServers(servers ServerContainer, typs []gatekeeper.Protocol, cb func(Server) error) error {
@@ -43,12 +57,29 @@ func filterServers(servers ServerContainer, typs []gatekeeper.Protocol, cb func(
 	}
 
 	for _, typ := range typs {
-		server, ok := servers[typ]
-		if !ok {
-			continue
+		for _, server := range servers[typ] {
+			errs.Add(cb(server))
 		}
-		errs.Add(cb(server))
 	}
 
 	return errs.ToErr()
 }
+
+// asyncFilterServers calls the callback concurrently for each of the servers
+// of the given types, collecting and returning any errors.
+func asyncFilterServers(servers ServerContainer, typs []gatekeeper.Protocol, cb func(Server) error) error {
+	errs := NewMultiError()
+	var wg sync.WaitGroup
+
+	filterServers(servers, typs, func(server Server) error {
+		wg.Add(1)
+		go func(server Server) {
+			defer wg.Done()
+			errs.Add(cb(server))
+		}(server)
+		return nil
+	})
+
+	wg.Wait()
+	return errs.ToErr()
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// testRouter routes every request to the same upstream, as a router plugin
+// might regardless of the request's protocol
+type testRouter struct {
+	upstream *gatekeeper.Upstream
+}
+
+func (r testRouter) RouteRequest(req *gatekeeper.Request) (*gatekeeper.Upstream, *gatekeeper.Request, error) {
+	return r.upstream, req, nil
+}
+
+func TestServerHTTPHandler_rejectsUpstreamsNotExposedOnProtocol(t *testing.T) {
+	upstream := &gatekeeper.Upstream{ID: "admin", Protocols: []gatekeeper.Protocol{gatekeeper.HTTPInternal}}
+	metricWriter := NewMetricWriter(1, 0).(*metricWriter)
+	loadBalancer := testLoadBalancer{}
+	s := newServer(ListenerConfig{Protocol: gatekeeper.HTTPPublic}, nil, nil, false, testRouter{upstream}, loadBalancer, NewLocalModifier(), nil, metricWriter).(*server)
+
+	rw := httptest.NewRecorder()
+	s.httpHandler(rw, httptest.NewRequest("GET", "http://example.com/admin", nil))
+	if rw.Code != 400 {
+		t.Fatalf("expected a 400, got %d %s", rw.Code, rw.Body)
+	}
+
+	metric := nextEventMetric(t, metricWriter, gatekeeper.RouteNotExposedEvent)
+	if metric.Extra["protocol"] != gatekeeper.HTTPPublic.String() {
+		t.Fatalf("unexpected metric %v", metric.Extra)
+	}
+}
+
+func TestHTTPSServer_terminatesTLS(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, req.Proto)
+	}))
+	defer backend.Close()
+
+	ca := newTestCertificate(t, nil, "ca")
+	certFile, keyFile := newTestCertificate(t, ca, "example.com", "example.com").writeFiles(t, t.TempDir(), "example.com")
+	metricWriter := NewMetricWriter(1, 0)
+	certificates, err := NewCertificateStore([]TLSCertificate{{certFile, keyFile}}, "", 0, metricWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := &gatekeeper.Upstream{ID: "web", Protocols: []gatekeeper.Protocol{gatekeeper.HTTPSPublic}}
+	s := NewHTTPSServer(
+		ListenerConfig{Protocol: gatekeeper.HTTPSPublic, Network: "tcp", Address: "127.0.0.1:0"},
+		buildTLSConfig(Options{HTTP2: true, TLSMinVersion: tls.VersionTLS12}, certificates),
+		certificates,
+		testRouter{upstream},
+		testLoadBalancer{"web": {ID: "web-1", Address: backend.URL}},
+		NewLocalModifier(),
+		NewProxier(time.Second, NewLocalModifier(), NewTransportManager(NewBroadcaster(), Options{}), metricWriter),
+		metricWriter,
+	).(*server)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(0)
+
+	// clients which trust the CA complete the handshake, negotiating
+	// HTTP/2, and are proxied to the backend
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: ca.pool(), ServerName: "example.com"},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + s.listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "HTTP/1.1" {
+		t.Fatalf("expected the backend's response, got %d %q", resp.StatusCode, body)
+	}
+	if resp.TLS == nil || resp.TLS.PeerCertificates[0].Subject.CommonName != "example.com" || resp.ProtoMajor != 2 {
+		t.Fatalf("expected an HTTP/2 response with the server's certificate, got %s %+v", resp.Proto, resp.TLS)
+	}
+
+	// and those which don't fail it
+	if _, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{ServerName: "example.com"}); err == nil {
+		t.Fatal("expected the handshake to fail without trusting the CA")
+	}
+}
+
+// newDrainTestServer starts a server proxying to a backend which holds each
+// request until release is called, sending on receivedCh as it arrives
+func newDrainTestServer(t *testing.T) (*server, string, chan struct{}, func()) {
+	receivedCh := make(chan struct{}, 10)
+	releaseCh := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedCh <- struct{}{}
+		<-releaseCh
+		io.WriteString(rw, "done")
+	}))
+	t.Cleanup(backend.Close)
+
+	var releaseOnce sync.Once
+	release := func() { releaseOnce.Do(func() { close(releaseCh) }) }
+	t.Cleanup(release)
+
+	upstream := &gatekeeper.Upstream{ID: "slow", Protocols: []gatekeeper.Protocol{gatekeeper.HTTPPublic}}
+	metricWriter := NewMetricWriter(1, 0)
+	proxier := NewProxier(10*time.Second, NewLocalModifier(), NewTransportManager(NewBroadcaster(), Options{}), metricWriter)
+	s := NewHTTPServer(
+		ListenerConfig{Protocol: gatekeeper.HTTPPublic, Network: "tcp", Address: "127.0.0.1:0"},
+		false,
+		testRouter{upstream},
+		testLoadBalancer{"slow": {ID: "slow-1", Address: backend.URL}},
+		NewLocalModifier(),
+		proxier,
+		metricWriter,
+	).(*server)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(0) })
+
+	return s, s.listener.Addr().String(), receivedCh, release
+}
+
+// startRequest sends a request to the server, returning its response on the
+// channel once the request has reached the backend
+func startRequest(t *testing.T, address string, receivedCh chan struct{}) chan *http.Response {
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + address + "/")
+		if err != nil {
+			t.Error(err)
+		}
+		respCh <- resp
+	}()
+
+	select {
+	case <-receivedCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to reach the backend")
+	}
+	return respCh
+}
+
+func TestServerDrain_finishesInFlightRequests(t *testing.T) {
+	s, address, receivedCh, release := newDrainTestServer(t)
+	respCh := startRequest(t, address, receivedCh)
+	if s.InFlight() != 1 {
+		t.Fatalf("expected one request in flight, got %d", s.InFlight())
+	}
+
+	drainErrCh := make(chan error, 1)
+	go func() { drainErrCh <- s.Drain(5 * time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// new connections are refused while the in-flight request finishes
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Fatal("expected new connections to be refused while draining")
+	}
+	select {
+	case err := <-drainErrCh:
+		t.Fatalf("expected Drain to wait for the in-flight request, got %v", err)
+	default:
+	}
+
+	release()
+	resp := <-respCh
+	if resp == nil {
+		t.FailNow()
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "done" {
+		t.Fatalf("expected the in-flight request to complete, got %d %q", resp.StatusCode, body)
+	}
+
+	if err := <-drainErrCh; err != nil {
+		t.Fatalf("expected Drain to finish, got %v", err)
+	}
+	if s.InFlight() != 0 {
+		t.Fatalf("expected no requests in flight, got %d", s.InFlight())
+	}
+}
+
+func TestServerDrain_timesOut(t *testing.T) {
+	s, address, receivedCh, _ := newDrainTestServer(t)
+	startRequest(t, address, receivedCh)
+
+	start := time.Now()
+	if err := s.Drain(100 * time.Millisecond); err != DrainTimeoutError {
+		t.Fatalf("expected DrainTimeoutError, got %v", err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected Drain to wait for the timeout, returned after %s", elapsed)
+	}
+	if s.InFlight() != 1 {
+		t.Fatalf("expected the request to still be in flight, got %d", s.InFlight())
+	}
+}
+
+func TestAppStop_stopsPluginsOnceDrained(t *testing.T) {
+	testCases := []struct {
+		// how long before the in-flight request finishes, if it does
+		release  time.Duration
+		timeout  time.Duration
+		inFlight int64
+	}{
+		{100 * time.Millisecond, 5 * time.Second, 0},
+		{0, 100 * time.Millisecond, 1},
+	}
+
+	for idx, testCase := range testCases {
+		s, address, receivedCh, release := newDrainTestServer(t)
+		startRequest(t, address, receivedCh)
+
+		var stoppedInFlight int64 = -1
+		var stoppedAfter time.Duration
+		start := time.Now()
+		manager := &testPluginManager{typ: RouterPlugin, onStop: func() {
+			stoppedInFlight = s.InFlight()
+			stoppedAfter = time.Now().Sub(start)
+		}}
+		app := newTestApp(s, manager)
+
+		if testCase.release > 0 {
+			time.AfterFunc(testCase.release, release)
+		}
+		app.Stop(testCase.timeout)
+
+		if stoppedInFlight != testCase.inFlight {
+			t.Fatalf("case %d: expected plugins to be stopped with %d requests in flight, got %d", idx, testCase.inFlight, stoppedInFlight)
+		}
+		if stoppedAfter < 100*time.Millisecond {
+			t.Fatalf("case %d: expected plugins to be stopped after draining, stopped after %s", idx, stoppedAfter)
+		}
+	}
+}
@@ -46,6 +46,17 @@ func CallWithTimeout(dur time.Duration, f func() error) (error, bool) {
 	return err, true
 }
 
+// untilDeadline returns the time remaining until the deadline, or zero if it
+// has already passed
+func untilDeadline(deadline time.Time) time.Duration {
+	remaining := deadline.Sub(time.Now())
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
 func pluginManagersToInterfaces(items []PluginManager) []interface{} {
 	if items == nil {
 		return []interface{}(nil)
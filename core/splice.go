@@ -0,0 +1,49 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// splice copies data in both directions between the client and backend
+// connections until either side is finished, returning the number of bytes
+// copied in each direction. When one direction finishes, the write side of
+// its destination is closed, where supported, so that the other direction can
+// finish cleanly; both connections are closed once both directions are done.
+func splice(client, backend io.ReadWriteCloser) (fromClient int64, fromBackend int64, err error) {
+	var wg sync.WaitGroup
+	errs := NewMultiError()
+
+	copyHalf := func(dst, src io.ReadWriteCloser, written *int64) {
+		defer wg.Done()
+
+		n, err := io.Copy(dst, src)
+		*written = n
+		if err != nil && !isClosedConnError(err) {
+			errs.Add(err)
+		}
+
+		// signal EOF to the destination, or close it entirely when it
+		// doesn't support half-closing, which ends the other direction
+		if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(backend, client, &fromClient)
+	go copyHalf(client, backend, &fromBackend)
+	wg.Wait()
+
+	client.Close()
+	backend.Close()
+	return fromClient, fromBackend, errs.ToErr()
+}
+
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
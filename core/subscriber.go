@@ -17,6 +17,9 @@ func NewSubscriber(broadcaster Broadcaster) Subscriber {
 	return &subscriber{
 		hooks:       make(map[gatekeeper.Event][]func(*UpstreamEvent)),
 		broadcaster: broadcaster,
+
+		doneCh: make(chan error, 1),
+		stopCh: make(chan struct{}, 1),
 	}
 }
 
@@ -24,18 +27,33 @@ type subscriber struct {
 	hooks       map[gatekeeper.Event][]func(*UpstreamEvent)
 	broadcaster Broadcaster
 
-	doneCh chan error
-	stopCh chan struct{}
+	doneCh  chan error
+	stopCh  chan struct{}
+	started bool
 
 	RWMutex
 }
 
 func (s *subscriber) Start() error {
+	s.Lock()
+	s.started = true
+	s.Unlock()
+
 	s.worker()
 	return nil
 }
 
 func (s *subscriber) Stop() error {
+	s.Lock()
+	started := s.started
+	s.started = false
+	s.Unlock()
+
+	// a subscriber that was never started has no worker to stop
+	if !started {
+		return nil
+	}
+
 	s.stopCh <- struct{}{}
 	return <-s.doneCh
 }
@@ -99,23 +117,21 @@ func (s *subscriber) worker() {
 	}
 
 	go func() {
-		var stopped bool
+		// the eventCh is not closed after removing the listener, as the
+		// broadcaster may still be publishing to it asynchronously
+		defer func() {
+			s.broadcaster.RemoveListener(listenerID)
+			wg.Wait()
+			s.doneCh <- errs.ToErr()
+		}()
+
 		for {
 			select {
 			case <-s.stopCh:
-				stopped = true
-			case event, _ := <-eventCh:
+				return
+			case event := <-eventCh:
 				handler(event)
-			default:
-			}
-
-			if stopped {
-				s.broadcaster.RemoveListener(listenerID)
-				close(eventCh)
 			}
 		}
-
-		wg.Wait()
-		s.doneCh <- errs.ToErr()
 	}()
 }
@@ -53,3 +53,9 @@ func (b *syncStartStopper) SyncStop(cb func() error) error {
 	}()
 	return cb()
 }
+
+func (b *syncStartStopper) Started() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.started
+}
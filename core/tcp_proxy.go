@@ -0,0 +1,419 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// TCPProxy proxies upstreams with the TCP protocol at the connection level. A
+// listener is bound to each such upstream's Port when the upstream is added,
+// and closed when it is removed. Each connection accepted on it is proxied to
+// one of the upstream's backends, as chosen by the LoadBalancer, until either
+// side closes it. A ConnectionMetric is written for every connection.
+//
+// During an upgrade, the listeners are passed along to the new process along
+// with the servers', and are picked up again as the new process' upstream
+// plugins re-add their upstreams.
+type TCPProxy interface {
+	starter
+	gracefulStopper
+
+	// UpgradeListeners returns the listener of each upstream, to be
+	// handed to the new process during an upgrade
+	UpgradeListeners() []upgradeListener
+}
+
+func NewTCPProxy(broadcaster Broadcaster, options Options, loadBalancer LoadBalancerClient, metricWriter MetricWriterClient) TCPProxy {
+	return &tcpProxy{
+		listenHost:     options.TCPListenHost,
+		proxyProtocol:  options.TCPProxyProtocol,
+		connectTimeout: options.DefaultTCPConnectTimeout,
+		dnsTimeout:     options.DefaultDNSTimeout,
+		upgradeTimeout: options.UpgradeTimeout,
+		loadBalancer:   loadBalancer,
+		metricWriter:   metricWriter,
+
+		listeners: make(map[gatekeeper.UpstreamID]*tcpListener),
+		inherited: make(map[string]net.Listener),
+		conns:     make(map[net.Conn]struct{}),
+
+		Subscriber: NewSubscriber(broadcaster),
+	}
+}
+
+type tcpProxy struct {
+	listenHost     string
+	proxyProtocol  *ProxyProtocolConfig
+	connectTimeout time.Duration
+	dnsTimeout     time.Duration
+	upgradeTimeout time.Duration
+	loadBalancer   LoadBalancerClient
+	metricWriter   MetricWriterClient
+
+	listeners map[gatekeeper.UpstreamID]*tcpListener
+	stopped   bool
+
+	// listeners passed along by a parent process during an upgrade, by
+	// address, which are used in place of binding the address again
+	inherited map[string]net.Listener
+
+	// open client and backend connections, which are closed if they are
+	// still open once the Stop duration has passed
+	conns   map[net.Conn]struct{}
+	connsWg sync.WaitGroup
+
+	Subscriber
+	RWMutex
+}
+
+// tcpListener is the listener bound for a single upstream. The upstream is
+// swapped out when it is re-added with the same port.
+type tcpListener struct {
+	listener net.Listener
+	upstream *gatekeeper.Upstream
+
+	// socket is the bound listener, before any PROXY protocol wrapping
+	address string
+	socket  net.Listener
+
+	sync.Mutex
+}
+
+func (l *tcpListener) ListenerConfig() ListenerConfig {
+	return ListenerConfig{
+		Protocol: gatekeeper.TCP,
+		Network:  "tcp",
+		Address:  l.address,
+	}
+}
+
+func (l *tcpListener) ListenerFile() (*os.File, error) {
+	return listenerFile(l.socket)
+}
+
+func (l *tcpListener) ListenerHandedOff() {}
+
+func (l *tcpListener) Upstream() *gatekeeper.Upstream {
+	l.Lock()
+	defer l.Unlock()
+	return l.upstream
+}
+
+func (l *tcpListener) setUpstream(upstream *gatekeeper.Upstream) {
+	l.Lock()
+	defer l.Unlock()
+	l.upstream = upstream
+}
+
+func (t *tcpProxy) Start() error {
+	// upstream plugins are given as long as an upgrade itself to re-add
+	// the upstreams whose listeners were inherited, after which any left
+	// over are closed
+	t.Lock()
+	t.inherited = inherited.takeTCPUpstreams()
+	if len(t.inherited) > 0 {
+		time.AfterFunc(t.upgradeTimeout, t.closeInherited)
+	}
+	t.Unlock()
+
+	t.AddUpstreamEventHook(gatekeeper.UpstreamAddedEvent, t.addUpstreamHook)
+	t.AddUpstreamEventHook(gatekeeper.UpstreamRemovedEvent, t.removeUpstreamHook)
+	return t.Subscriber.Start()
+}
+
+// Stop closes each listener and waits, up to the given duration, for open
+// connections to finish, closing any which are still open after it.
+func (t *tcpProxy) Stop(duration time.Duration) error {
+	err := t.Subscriber.Stop()
+
+	t.Lock()
+	t.stopped = true
+	for upstreamID, listener := range t.listeners {
+		t.closeListener(listener)
+		delete(t.listeners, upstreamID)
+	}
+	t.Unlock()
+	t.closeInherited()
+
+	doneCh := make(chan struct{})
+	go func() {
+		t.connsWg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		return err
+	case <-time.After(duration):
+	}
+
+	t.Lock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.Unlock()
+
+	<-doneCh
+	if err != nil {
+		return err
+	}
+	return DrainTimeoutError
+}
+
+func (t *tcpProxy) addUpstreamHook(event *UpstreamEvent) {
+	if !event.Upstream.HasProtocol(gatekeeper.TCP) {
+		t.removeUpstreamHook(event)
+		return
+	}
+
+	if event.Upstream.Port == 0 {
+		t.listenerEventMetric(gatekeeper.TCPListenerErrorEvent, event.Upstream, "", TCPUpstreamPortRequiredError)
+		return
+	}
+
+	address := net.JoinHostPort(t.listenHost, strconv.Itoa(int(event.Upstream.Port)))
+
+	t.Lock()
+	defer t.Unlock()
+
+	// an upstream re-added on the same port keeps its listener, otherwise
+	// its previous listener is closed before binding the new port
+	if existing, ok := t.listeners[event.UpstreamID]; ok {
+		if existing.Upstream().Port == event.Upstream.Port {
+			existing.setUpstream(event.Upstream)
+			return
+		}
+		t.closeListener(existing)
+		delete(t.listeners, event.UpstreamID)
+	}
+
+	socket, ok := t.inherited[address]
+	delete(t.inherited, address)
+	if !ok {
+		var err error
+		socket, err = net.Listen("tcp", address)
+		if err != nil {
+			t.listenerEventMetric(gatekeeper.TCPListenerErrorEvent, event.Upstream, address, err)
+			return
+		}
+	}
+
+	rawListener := socket
+	if t.proxyProtocol != nil {
+		rawListener = newProxyProtocolListener(rawListener, t.proxyProtocol)
+	}
+
+	listener := &tcpListener{
+		listener: rawListener,
+		upstream: event.Upstream,
+		address:  address,
+		socket:   socket,
+	}
+	t.listeners[event.UpstreamID] = listener
+	t.listenerEventMetric(gatekeeper.TCPListenerStartedEvent, event.Upstream, address, nil)
+
+	go t.accept(listener)
+}
+
+func (t *tcpProxy) removeUpstreamHook(event *UpstreamEvent) {
+	t.Lock()
+	defer t.Unlock()
+
+	listener, ok := t.listeners[event.UpstreamID]
+	if !ok {
+		return
+	}
+
+	t.closeListener(listener)
+	delete(t.listeners, event.UpstreamID)
+}
+
+// closeListener stops accepting connections for the listener's upstream;
+// connections which are already open are left to finish
+func (t *tcpProxy) closeListener(listener *tcpListener) {
+	listener.listener.Close()
+	t.listenerEventMetric(gatekeeper.TCPListenerStoppedEvent, listener.Upstream(), listener.listener.Addr().String(), nil)
+}
+
+// closeInherited closes the inherited listeners which weren't claimed by an
+// upstream
+func (t *tcpProxy) closeInherited() {
+	t.Lock()
+	defer t.Unlock()
+
+	for address, listener := range t.inherited {
+		listener.Close()
+		delete(t.inherited, address)
+	}
+}
+
+func (t *tcpProxy) UpgradeListeners() []upgradeListener {
+	t.RLock()
+	defer t.RUnlock()
+
+	listeners := make([]upgradeListener, 0, len(t.listeners))
+	for _, listener := range t.listeners {
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+func (t *tcpProxy) accept(listener *tcpListener) {
+	for {
+		conn, err := listener.listener.Accept()
+		if err != nil {
+			if !isClosedConnError(err) {
+				log.Println(err)
+			}
+			return
+		}
+
+		if !t.trackConn(conn) {
+			conn.Close()
+			continue
+		}
+		go t.proxy(conn, listener)
+	}
+}
+
+// proxy connects the client connection to a backend of the listener's
+// upstream, and splices the two together until either side is finished
+func (t *tcpProxy) proxy(clientConn net.Conn, listener *tcpListener) {
+	defer t.untrackConn(clientConn)
+
+	upstream := listener.Upstream()
+	metric := &gatekeeper.ConnectionMetric{
+		Timestamp:         time.Now(),
+		Upstream:          upstream,
+		ClientAddress:     clientConn.RemoteAddr().String(),
+		ListenAddress:     clientConn.LocalAddr().String(),
+		ConnectionStartTS: time.Now(),
+	}
+	defer func() {
+		metric.ConnectionEndTS = time.Now()
+		metric.Duration = metric.ConnectionEndTS.Sub(metric.ConnectionStartTS)
+		t.metricWriter.ConnectionMetric(metric)
+	}()
+
+	backendConn, err := t.connect(clientConn, upstream, metric)
+	if err != nil {
+		metric.ConnectError = true
+		metric.Error = gatekeeper.NewError(err)
+		clientConn.Close()
+		return
+	}
+
+	if !t.trackConn(backendConn) {
+		clientConn.Close()
+		backendConn.Close()
+		return
+	}
+	defer t.untrackConn(backendConn)
+
+	fromClient, fromBackend, err := splice(clientConn, backendConn)
+	metric.BytesFromClient = fromClient
+	metric.BytesFromBackend = fromBackend
+	metric.Error = gatekeeper.NewError(err)
+}
+
+// connect picks a backend for the upstream and dials it, recording the load
+// balancer and connect latencies on the metric. Clients which were required to
+// send a PROXY header and didn't are not connected, and a PROXY header is sent
+// to the backend when the upstream is configured to.
+func (t *tcpProxy) connect(clientConn net.Conn, upstream *gatekeeper.Upstream, metric *gatekeeper.ConnectionMetric) (net.Conn, error) {
+	if conn, ok := clientConn.(*proxyProtocolConn); ok {
+		if err := conn.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	lbStartTS := time.Now()
+	backend, err := t.loadBalancer.GetBackend(upstream.ID)
+	metric.LoadBalancerLatency = time.Now().Sub(lbStartTS)
+	if err != nil {
+		return nil, err
+	}
+	metric.Backend = backend
+
+	address, err := tcpBackendAddress(backend.Address)
+	if err != nil {
+		return nil, err
+	}
+	metric.BackendAddress = address
+
+	dial := dialContext(upstreamConnectTimeout(upstream, t.connectTimeout), t.dnsTimeout)
+	connectStartTS := time.Now()
+	conn, err := dial(context.Background(), "tcp", address)
+	metric.ConnectLatency = time.Now().Sub(connectStartTS)
+	if err != nil || upstream.ProxyProtocol == 0 {
+		return conn, err
+	}
+
+	if err := writeProxyProtocolHeader(conn, upstream.ProxyProtocol, clientConn.RemoteAddr(), clientConn.LocalAddr()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// trackConn records an open connection, so that it can be closed when the
+// proxy is stopped. It returns false once the proxy is no longer running.
+func (t *tcpProxy) trackConn(conn net.Conn) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.stopped {
+		return false
+	}
+
+	t.conns[conn] = struct{}{}
+	t.connsWg.Add(1)
+	return true
+}
+
+func (t *tcpProxy) untrackConn(conn net.Conn) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.conns, conn)
+	t.connsWg.Done()
+}
+
+func (t *tcpProxy) listenerEventMetric(event gatekeeper.Event, upstream *gatekeeper.Upstream, address string, err error) {
+	extra := map[string]string{
+		"upstream_id": string(upstream.ID),
+		"address":     address,
+	}
+	if err != nil {
+		log.Println(err)
+		extra["error"] = err.Error()
+	}
+
+	t.metricWriter.EventMetric(&gatekeeper.EventMetric{
+		Timestamp: time.Now(),
+		Event:     event,
+		Extra:     extra,
+	})
+}
+
+// tcpBackendAddress returns the host:port of a backend of a TCP upstream, whose
+// address is either a host:port pair or a URL such as `tcp://host:port`
+func tcpBackendAddress(address string) (string, error) {
+	if backendURL, err := url.Parse(address); err == nil && backendURL.Host != "" {
+		address = backendURL.Host
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", BackendAddressError
+	}
+
+	return address, nil
+}
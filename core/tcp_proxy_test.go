@@ -0,0 +1,189 @@
+package core
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+type testLoadBalancer map[gatekeeper.UpstreamID]*gatekeeper.Backend
+
+func (l testLoadBalancer) GetBackend(upstreamID gatekeeper.UpstreamID) (*gatekeeper.Backend, error) {
+	if backend, ok := l[upstreamID]; ok {
+		return backend, nil
+	}
+	return nil, BackendNotFoundError
+}
+
+// newEchoBackend listens for connections, writing back whatever each one
+// sends until it closes its side
+func newEchoBackend(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener
+}
+
+// newTestTCPProxy adds an upstream to a tcp proxy with the given backend,
+// returning the address its listener is bound to
+func newTestTCPProxy(t *testing.T, backendAddress string) (*tcpProxy, string, *metricWriter) {
+	metricWriter := NewMetricWriter(1, 0).(*metricWriter)
+	loadBalancer := testLoadBalancer{"echo": {ID: "echo-1", Address: backendAddress}}
+	proxy := NewTCPProxy(NewBroadcaster(), Options{
+		TCPListenHost:            "127.0.0.1",
+		DefaultTCPConnectTimeout: time.Second,
+	}, loadBalancer, metricWriter).(*tcpProxy)
+
+	// bind the port up front, as a parent process would have, so that the
+	// upstream's listener picks it up
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := socket.Addr().String()
+	proxy.inherited[address] = socket
+
+	upstream := &gatekeeper.Upstream{
+		ID:        "echo",
+		Protocols: []gatekeeper.Protocol{gatekeeper.TCP},
+		Port:      uint(socket.Addr().(*net.TCPAddr).Port),
+	}
+	proxy.addUpstreamHook(&UpstreamEvent{Upstream: upstream, UpstreamID: upstream.ID})
+	if _, ok := proxy.listeners[upstream.ID]; !ok {
+		t.Fatal("expected a listener for the upstream")
+	}
+	t.Cleanup(func() { proxy.Stop(0) })
+	return proxy, address, metricWriter
+}
+
+// nextConnectionMetric returns the next ConnectionMetric written, skipping
+// over any other metrics
+func nextConnectionMetric(t *testing.T, metricWriter *metricWriter) *gatekeeper.ConnectionMetric {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case metric := <-metricWriter.bufferCh:
+			if metric, ok := metric.(*gatekeeper.ConnectionMetric); ok {
+				return metric
+			}
+		case <-timeout:
+			t.Fatal("expected a connection metric")
+			return nil
+		}
+	}
+}
+
+func TestTCPProxy_splicesConnections(t *testing.T) {
+	backend := newEchoBackend(t)
+	_, address, metricWriter := newTestTCPProxy(t, "tcp://"+backend.Addr().String())
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "ping")
+	conn.(*net.TCPConn).CloseWrite()
+	if body, err := io.ReadAll(conn); err != nil || string(body) != "ping" {
+		t.Fatalf("expected the backend's echo, got %q %v", body, err)
+	}
+
+	metric := nextConnectionMetric(t, metricWriter)
+	if metric.BytesFromClient != 4 || metric.BytesFromBackend != 4 {
+		t.Fatalf("expected 4 bytes each way, got %d and %d", metric.BytesFromClient, metric.BytesFromBackend)
+	}
+	if metric.BackendAddress != backend.Addr().String() || metric.Backend.ID != "echo-1" || metric.ConnectError {
+		t.Fatalf("unexpected metric %+v", metric)
+	}
+}
+
+func TestTCPProxy_closesClientsWhenConnectFails(t *testing.T) {
+	backend := newEchoBackend(t)
+	backendAddress := backend.Addr().String()
+	backend.Close()
+
+	for _, address := range []string{backendAddress, "not-an-address"} {
+		_, proxyAddress, metricWriter := newTestTCPProxy(t, address)
+
+		conn, err := net.Dial("tcp", proxyAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(conn); len(body) != 0 {
+			t.Fatalf("%s: expected the connection to be closed, got %q", address, body)
+		}
+		conn.Close()
+
+		if metric := nextConnectionMetric(t, metricWriter); !metric.ConnectError || metric.Error == nil {
+			t.Fatalf("%s: expected a connect error, got %+v", address, metric)
+		}
+	}
+}
+
+func TestTCPProxyStop_drainsConnections(t *testing.T) {
+	backend := newEchoBackend(t)
+
+	testCases := []struct {
+		// whether the client finishes its connection while draining
+		finish bool
+		err    error
+	}{
+		{true, nil},
+		{false, DrainTimeoutError},
+	}
+
+	for idx, testCase := range testCases {
+		proxy, address, _ := newTestTCPProxy(t, backend.Addr().String())
+
+		// a round trip makes sure the connection is being spliced
+		// before stopping
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		io.WriteString(conn, "ping")
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- proxy.Stop(200 * time.Millisecond) }()
+
+		if testCase.finish {
+			conn.(*net.TCPConn).CloseWrite()
+		}
+		if err := <-errCh; err != testCase.err {
+			t.Fatalf("case %d: expected %v, got %v", idx, testCase.err, err)
+		}
+
+		// open connections are closed, and no more are accepted
+		if body, _ := io.ReadAll(conn); len(body) != 0 {
+			t.Fatalf("case %d: expected the connection to be closed, got %q", idx, body)
+		}
+		conn.Close()
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			t.Fatalf("case %d: expected the listener to be closed", idx)
+		}
+	}
+}
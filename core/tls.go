@@ -0,0 +1,194 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// buildTLSConfig builds the *tls.Config shared by the https servers.
+// Certificates are served from the CertificateStore on each handshake, so
+// that they can be reloaded without rebuilding the config or the listeners.
+func buildTLSConfig(options Options, certificates CertificateStore) *tls.Config {
+	nextProtos := []string{"http/1.1"}
+	if options.HTTP2 {
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+
+	return &tls.Config{
+		GetCertificate: certificates.GetCertificate,
+		MinVersion:     options.TLSMinVersion,
+		CipherSuites:   options.TLSCipherSuites,
+		NextProtos:     nextProtos,
+	}
+}
+
+// buildClientAuthTLSConfig returns a copy of the tls.Config which requests a
+// certificate from clients, advertising the CertificateStore's current client
+// CA bundle. Certificates are verified against the bundle once per connection,
+// when its first request is served, rather than during the handshake, so that
+// each upstream's ClientAuth policy decides what happens to requests without a
+// valid one.
+func buildClientAuthTLSConfig(tlsConfig *tls.Config, certificates CertificateStore) *tls.Config {
+	clientAuthTLSConfig := tlsConfig.Clone()
+	clientAuthTLSConfig.ClientAuth = tls.RequestClientCert
+	clientAuthTLSConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := tlsConfig.Clone()
+		config.ClientAuth = tls.RequestClientCert
+		config.ClientCAs = certificates.ClientCAs()
+		return config, nil
+	}
+	return clientAuthTLSConfig
+}
+
+// loadClientCAs reads the PEM bundle of CAs which client certificates are
+// verified against
+func loadClientCAs(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: %s", InvalidTLSClientCAError, caFile)
+	}
+	return pool, nil
+}
+
+type clientCertificateKey struct{}
+
+// connClientCertificate holds the result of verifying the certificate which a
+// client presented on a connection, which is shared by each of the requests
+// served on it.
+type connClientCertificate struct {
+	once sync.Once
+	cert *gatekeeper.ClientCertificate
+	err  error
+}
+
+// withConnClientCertificate is an http.Server.ConnContext, which gives each
+// connection somewhere to hold its verified client certificate
+func withConnClientCertificate(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, clientCertificateKey{}, &connClientCertificate{})
+}
+
+// connVerifyClientCertificate verifies the client certificate of the
+// request's connection against the CertificateStore's client CA bundle the
+// first time it is called for the connection, returning the same result for
+// each subsequent request on it.
+func connVerifyClientCertificate(req *http.Request, certificates CertificateStore) (*gatekeeper.ClientCertificate, error) {
+	verify := func() (*gatekeeper.ClientCertificate, error) {
+		if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+			return nil, nil
+		}
+
+		var pool *x509.CertPool
+		if certificates != nil {
+			pool = certificates.ClientCAs()
+		}
+		return verifyClientCertificate(req.TLS, pool)
+	}
+
+	conn, ok := req.Context().Value(clientCertificateKey{}).(*connClientCertificate)
+	if !ok {
+		return verify()
+	}
+
+	conn.once.Do(func() {
+		conn.cert, conn.err = verify()
+	})
+	return conn.cert, conn.err
+}
+
+// verifyClientCertificate verifies the certificate chain a client presented
+// against the CA pool, returning nil when the client didn't present one.
+func verifyClientCertificate(state *tls.ConnectionState, pool *x509.CertPool) (*gatekeeper.ClientCertificate, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil, nil
+	}
+
+	if pool == nil {
+		return nil, InvalidClientCertificateError
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := state.PeerCertificates[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, InvalidClientCertificateError
+	}
+
+	return gatekeeper.NewClientCertificate(leaf), nil
+}
+
+// checkClientAuth applies an upstream's ClientAuth policy to a request, given
+// its verified certificate and any error from verifying it.
+func checkClientAuth(clientAuth gatekeeper.ClientAuth, cert *gatekeeper.ClientCertificate, verifyErr error) error {
+	switch clientAuth {
+	case gatekeeper.OptionalClientAuth:
+		return verifyErr
+	case gatekeeper.RequireClientAuth:
+		if verifyErr != nil {
+			return verifyErr
+		}
+		if cert == nil {
+			return ClientCertificateRequiredError
+		}
+	}
+
+	return nil
+}
+
+// buildBackendTLSConfig builds the *tls.Config used to connect to an
+// upstream's https backends, returning nil when it has no TLS settings so that
+// the transport's defaults are used.
+func buildBackendTLSConfig(backendTLS gatekeeper.BackendTLS) (*tls.Config, error) {
+	if backendTLS == (gatekeeper.BackendTLS{}) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         backendTLS.ServerName,
+		InsecureSkipVerify: backendTLS.InsecureSkipVerify,
+	}
+
+	if backendTLS.CAFile != "" {
+		pem, err := os.ReadFile(backendTLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %s", InvalidBackendTLSCAError, backendTLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if backendTLS.CertFile != "" || backendTLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(backendTLS.CertFile, backendTLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
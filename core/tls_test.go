@@ -0,0 +1,153 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate issues a certificate for the common name and DNS names
+// from the parent, or a self-signed CA when parent is nil
+func newTestCertificate(t *testing.T, parent *testCertificate, commonName string, dnsNames ...string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	signer := &testCertificate{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer = parent
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+// writeFiles writes the certificate and key as PEM files into the directory,
+// returning their paths
+func (c *testCertificate) writeFiles(t *testing.T, dir, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCertificate) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+func TestCheckClientAuth(t *testing.T) {
+	cert := &gatekeeper.ClientCertificate{}
+
+	testCases := []struct {
+		clientAuth gatekeeper.ClientAuth
+		cert       *gatekeeper.ClientCertificate
+		verifyErr  error
+		err        error
+	}{
+		{gatekeeper.NoClientAuth, nil, nil, nil},
+		{gatekeeper.NoClientAuth, nil, InvalidClientCertificateError, nil},
+		{gatekeeper.OptionalClientAuth, nil, nil, nil},
+		{gatekeeper.OptionalClientAuth, cert, nil, nil},
+		{gatekeeper.OptionalClientAuth, nil, InvalidClientCertificateError, InvalidClientCertificateError},
+		{gatekeeper.RequireClientAuth, cert, nil, nil},
+		{gatekeeper.RequireClientAuth, nil, nil, ClientCertificateRequiredError},
+		{gatekeeper.RequireClientAuth, nil, InvalidClientCertificateError, InvalidClientCertificateError},
+	}
+
+	for idx, testCase := range testCases {
+		if err := checkClientAuth(testCase.clientAuth, testCase.cert, testCase.verifyErr); err != testCase.err {
+			t.Fatalf("case %d: expected %v, got %v", idx, testCase.err, err)
+		}
+	}
+}
+
+func TestConnVerifyClientCertificate_verifiesOncePerConnection(t *testing.T) {
+	ca := newTestCertificate(t, nil, "client-ca")
+	client := newTestCertificate(t, ca, "client")
+	untrusted := newTestCertificate(t, newTestCertificate(t, nil, "other-ca"), "client")
+
+	certificates := &certificateStore{clientCAs: ca.pool()}
+	newConnRequest := func(cert *testCertificate) *http.Request {
+		req, _ := http.NewRequest("GET", "https://example.com/", nil)
+		req = req.WithContext(withConnClientCertificate(req.Context(), nil))
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}
+		return req
+	}
+
+	req := newConnRequest(client)
+	if cert, err := connVerifyClientCertificate(req, certificates); err != nil || cert == nil || cert.Subject != "CN=client" {
+		t.Fatalf("expected a verified certificate, got %v %v", cert, err)
+	}
+	if _, err := connVerifyClientCertificate(newConnRequest(untrusted), certificates); err != InvalidClientCertificateError {
+		t.Fatalf("expected InvalidClientCertificateError, got %v", err)
+	}
+
+	// reloading the CA bundle applies to new connections, while open ones
+	// keep the result of their first verification
+	certificates.clientCAs = x509.NewCertPool()
+	if cert, err := connVerifyClientCertificate(req, certificates); err != nil || cert == nil {
+		t.Fatalf("expected the connection's verified certificate, got %v %v", cert, err)
+	}
+	if _, err := connVerifyClientCertificate(newConnRequest(client), certificates); err != InvalidClientCertificateError {
+		t.Fatalf("expected InvalidClientCertificateError, got %v", err)
+	}
+
+	// connections without a certificate aren't verified at all
+	req, _ = http.NewRequest("GET", "https://example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	if cert, err := connVerifyClientCertificate(req, nil); cert != nil || err != nil {
+		t.Fatalf("expected no certificate, got %v %v", cert, err)
+	}
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// requestTrace records the connection level timings of a proxied request by
+// way of an httptrace.ClientTrace. The trace callbacks are called from the
+// transport's goroutines, so each field is guarded by the mutex.
+type requestTrace struct {
+	startTS time.Time
+
+	dnsStartTS          time.Time
+	dnsLookup           bool
+	dnsLookupLatency    time.Duration
+	connectStartTS      time.Time
+	tcpConnectLatency   time.Duration
+	tlsStartTS          time.Time
+	tlsHandshakeLatency time.Duration
+	tlsHandshakeErr     error
+	timeToFirstByte     time.Duration
+
+	connReused   bool
+	connWasIdle  bool
+	connIdleTime time.Duration
+
+	sync.Mutex
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{
+		startTS: time.Now(),
+	}
+}
+
+func (r *requestTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.Lock()
+			defer r.Unlock()
+			r.dnsStartTS = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.Lock()
+			defer r.Unlock()
+			r.dnsLookup = true
+			r.dnsLookupLatency = time.Now().Sub(r.dnsStartTS)
+		},
+		ConnectStart: func(string, string) {
+			r.Lock()
+			defer r.Unlock()
+			r.connectStartTS = time.Now()
+		},
+		// when dialing multiple addresses, such as with both ipv4 and
+		// ipv6 records, only the successful connection is recorded
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				return
+			}
+
+			r.Lock()
+			defer r.Unlock()
+			r.tcpConnectLatency = time.Now().Sub(r.connectStartTS)
+		},
+		TLSHandshakeStart: func() {
+			r.Lock()
+			defer r.Unlock()
+			r.tlsStartTS = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			r.Lock()
+			defer r.Unlock()
+			r.tlsHandshakeLatency = time.Now().Sub(r.tlsStartTS)
+			r.tlsHandshakeErr = err
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.Lock()
+			defer r.Unlock()
+			r.connReused = info.Reused
+			r.connWasIdle = info.WasIdle
+			r.connIdleTime = info.IdleTime
+		},
+		GotFirstResponseByte: func() {
+			r.Lock()
+			defer r.Unlock()
+			r.timeToFirstByte = time.Now().Sub(r.startTS)
+		},
+	}
+}
+
+// writeMetric copies the recorded timings into the RequestMetric
+func (r *requestTrace) writeMetric(metric *gatekeeper.RequestMetric) {
+	r.Lock()
+	defer r.Unlock()
+
+	metric.DNSLookup = r.dnsLookup
+	metric.DNSLookupLatency = r.dnsLookupLatency
+	metric.TCPConnectLatency = r.tcpConnectLatency
+	metric.TLSHandshakeLatency = r.tlsHandshakeLatency
+	metric.TimeToFirstByte = r.timeToFirstByte
+
+	metric.ConnReused = r.connReused
+	metric.ConnWasIdle = r.connWasIdle
+	metric.ConnIdleTime = r.connIdleTime
+}
+
+// proxyError returns the error for a request which failed to reach the
+// backend, distinguishing failed TLS handshakes, such as with a backend whose
+// certificate isn't trusted, from other connection errors. With TLS 1.3, a
+// backend rejecting the client certificate is only seen after the handshake,
+// as an alert from the backend. Timeouts and canceled requests are returned
+// as they are.
+func (r *requestTrace) proxyError(err error) error {
+	if err == ProxyTimeoutError || err == ClientCanceledError {
+		return err
+	}
+
+	r.Lock()
+	handshakeErr := r.tlsHandshakeErr
+	r.Unlock()
+
+	var opErr *net.OpError
+	if handshakeErr == nil && errors.As(err, &opErr) && opErr.Op == "remote error" {
+		handshakeErr = err
+	}
+
+	if handshakeErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", BackendTLSHandshakeError, handshakeErr)
+}
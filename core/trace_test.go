@@ -0,0 +1,113 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// newTLSBackend serves https on localhost with a certificate issued by a new
+// CA, returning its address and the path of the CA's certificate
+func newTLSBackend(t *testing.T) (string, string) {
+	ca := newTestCertificate(t, nil, "ca")
+	caFile, _ := ca.writeFiles(t, t.TempDir(), "ca")
+	cert := newTestCertificate(t, ca, "localhost", "localhost")
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "ok")
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}},
+	}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	return "https://localhost:" + port, caFile
+}
+
+// errorModifier records the error of each error response it is passed
+type errorModifier struct {
+	localModifier
+	err error
+}
+
+func (m *errorModifier) ModifyErrorResponse(err error, req *gatekeeper.Request, resp *gatekeeper.Response) (*gatekeeper.Response, error) {
+	m.err = err
+	return resp, nil
+}
+
+func TestProxierProxy_tracesBackendConnections(t *testing.T) {
+	address, caFile := newTLSBackend(t)
+	transports := NewTransportManager(NewBroadcaster(), Options{}).(*transportManager)
+	proxier := NewProxier(time.Second, NewLocalModifier(), transports, NewMetricWriter(10, time.Second))
+
+	upstream := &gatekeeper.Upstream{ID: "billing", BackendTLS: gatekeeper.BackendTLS{CAFile: caFile}}
+	backend := &gatekeeper.Backend{ID: "billing-1", Address: address}
+	transports.addUpstreamHook(&UpstreamEvent{Upstream: upstream, UpstreamID: upstream.ID})
+
+	proxy := func() *gatekeeper.RequestMetric {
+		rw := httptest.NewRecorder()
+		httpReq := httptest.NewRequest("GET", "/", nil)
+		metric := &gatekeeper.RequestMetric{}
+		if err := proxier.Proxy(rw, httpReq, gatekeeper.NewRequest(httpReq, gatekeeper.HTTPPublic), upstream, backend, metric); err != nil {
+			t.Fatal(err)
+		}
+		if rw.Code != 200 || rw.Body.String() != "ok" {
+			t.Fatalf("expected the backend's response, got %d %q", rw.Code, rw.Body)
+		}
+		return metric
+	}
+
+	// the first request dials and handshakes with the backend
+	metric := proxy()
+	if !metric.DNSLookup || metric.TCPConnectLatency == 0 || metric.TLSHandshakeLatency == 0 || metric.TimeToFirstByte == 0 {
+		t.Fatalf("expected the connection to be traced, got %+v", metric)
+	}
+	if metric.ConnReused {
+		t.Fatal("expected a new connection on the first request")
+	}
+
+	// the second reuses its connection
+	metric = proxy()
+	if !metric.ConnReused || !metric.ConnWasIdle || metric.TCPConnectLatency != 0 || metric.TLSHandshakeLatency != 0 {
+		t.Fatalf("expected the connection to be reused, got %+v", metric)
+	}
+	if metric.TimeToFirstByte == 0 {
+		t.Fatal("expected the time to first byte to be traced")
+	}
+}
+
+func TestProxierProxy_reportsBackendTLSHandshakeErrors(t *testing.T) {
+	// the backend's CA isn't trusted by the system roots
+	address, _ := newTLSBackend(t)
+	modifier := &errorModifier{}
+	proxier := NewProxier(time.Second, modifier, NewTransportManager(NewBroadcaster(), Options{}), NewMetricWriter(10, time.Second))
+
+	upstream := &gatekeeper.Upstream{ID: "billing"}
+	backend := &gatekeeper.Backend{ID: "billing-1", Address: address}
+
+	rw := httptest.NewRecorder()
+	httpReq := httptest.NewRequest("GET", "/", nil)
+	metric := &gatekeeper.RequestMetric{}
+	if err := proxier.Proxy(rw, httpReq, gatekeeper.NewRequest(httpReq, gatekeeper.HTTPPublic), upstream, backend, metric); err != nil {
+		t.Fatal(err)
+	}
+
+	if rw.Code != 502 || !metric.BackendTLSHandshakeFailed {
+		t.Fatalf("expected a failed handshake, got %d %t", rw.Code, metric.BackendTLSHandshakeFailed)
+	}
+	if !errors.Is(modifier.err, BackendTLSHandshakeError) {
+		t.Fatalf("expected %v, got %v", BackendTLSHandshakeError, modifier.err)
+	}
+	if metric.TLSHandshakeLatency == 0 {
+		t.Fatal("expected the failed handshake to be traced")
+	}
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// defaults for the upstream settings which configure its transport
+const (
+	defaultMaxIdleConnsPerHost = 200
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+)
+
+// TransportManager maintains an http.Transport for each upstream, so that
+// each upstream has its own connection pool and connection settings.
+// Transports are built when an upstream is added and their idle connections
+// are closed when it is removed.
+type TransportManager interface {
+	starter
+	stopper
+
+	Transport(*gatekeeper.Upstream) http.RoundTripper
+}
+
+func NewTransportManager(broadcaster Broadcaster, options Options) TransportManager {
+	return &transportManager{
+		connectTimeout: options.DefaultTCPConnectTimeout,
+		dnsTimeout:     options.DefaultDNSTimeout,
+		transports:     make(map[gatekeeper.UpstreamID]*http.Transport),
+		settings:       make(map[gatekeeper.UpstreamID]transportSettings),
+
+		Subscriber: NewSubscriber(broadcaster),
+	}
+}
+
+type transportManager struct {
+	connectTimeout time.Duration
+	dnsTimeout     time.Duration
+	transports     map[gatekeeper.UpstreamID]*http.Transport
+	settings       map[gatekeeper.UpstreamID]transportSettings
+
+	Subscriber
+	RWMutex
+}
+
+func (t *transportManager) Start() error {
+	t.AddUpstreamEventHook(gatekeeper.UpstreamAddedEvent, t.addUpstreamHook)
+	t.AddUpstreamEventHook(gatekeeper.UpstreamRemovedEvent, t.removeUpstreamHook)
+	return t.Subscriber.Start()
+}
+
+func (t *transportManager) Stop() error {
+	err := t.Subscriber.Stop()
+
+	t.Lock()
+	defer t.Unlock()
+	for upstreamID, transport := range t.transports {
+		transport.CloseIdleConnections()
+		delete(t.transports, upstreamID)
+		delete(t.settings, upstreamID)
+	}
+
+	return err
+}
+
+// Transport returns the transport for the upstream. Requests for an upstream
+// which the transportManager doesn't know about, such as one which was just
+// removed or whose UpstreamAddedEvent hasn't arrived yet, are given a transport
+// which isn't kept, and which doesn't keep its connections open, so that
+// nothing is left behind for an upstream which is never added.
+func (t *transportManager) Transport(upstream *gatekeeper.Upstream) http.RoundTripper {
+	t.RLock()
+	transport, ok := t.transports[upstream.ID]
+	t.RUnlock()
+	if ok {
+		return transport
+	}
+
+	transport = t.buildTransport(upstream)
+	transport.DisableKeepAlives = true
+	return transport
+}
+
+func (t *transportManager) addUpstreamHook(event *UpstreamEvent) {
+	settings := upstreamTransportSettings(event.Upstream)
+
+	t.Lock()
+	defer t.Unlock()
+
+	// an upstream being re-added may have changed its settings, so its
+	// existing transport is replaced unless they are the same, such as
+	// when only its weight in a traffic split changed
+	existing, ok := t.transports[event.UpstreamID]
+	if ok && t.settings[event.UpstreamID] == settings {
+		return
+	}
+	if ok {
+		existing.CloseIdleConnections()
+	}
+	t.transports[event.UpstreamID] = t.buildTransport(event.Upstream)
+	t.settings[event.UpstreamID] = settings
+}
+
+func (t *transportManager) removeUpstreamHook(event *UpstreamEvent) {
+	t.Lock()
+	defer t.Unlock()
+
+	transport, ok := t.transports[event.UpstreamID]
+	if !ok {
+		return
+	}
+
+	transport.CloseIdleConnections()
+	delete(t.transports, event.UpstreamID)
+	delete(t.settings, event.UpstreamID)
+}
+
+// transportSettings are the settings of an upstream which its transport is
+// built from
+type transportSettings struct {
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+	connectTimeout        time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	backendProtocol       gatekeeper.BackendProtocol
+	proxyProtocol         uint
+	backendTLS            gatekeeper.BackendTLS
+}
+
+func upstreamTransportSettings(upstream *gatekeeper.Upstream) transportSettings {
+	return transportSettings{
+		maxIdleConnsPerHost:   upstream.MaxIdleConnsPerHost,
+		idleConnTimeout:       upstream.IdleConnTimeout,
+		connectTimeout:        upstream.ConnectTimeout,
+		tlsHandshakeTimeout:   upstream.TLSHandshakeTimeout,
+		responseHeaderTimeout: upstream.ResponseHeaderTimeout,
+		backendProtocol:       upstream.BackendProtocol,
+		proxyProtocol:         upstream.ProxyProtocol,
+		backendTLS:            upstream.BackendTLS,
+	}
+}
+
+// buildTransport builds a transport from the upstream's settings, falling
+// back to the defaults for any which are not set.
+func (t *transportManager) buildTransport(upstream *gatekeeper.Upstream) *http.Transport {
+	maxIdleConnsPerHost := upstream.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	idleConnTimeout := upstream.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	tlsHandshakeTimeout := upstream.TLSHandshakeTimeout
+	if tlsHandshakeTimeout == 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	// a PROXY header describes a single client, so connections which
+	// send one are never reused for another request
+	dial := dialContext(upstreamConnectTimeout(upstream, t.connectTimeout), t.dnsTimeout)
+	if upstream.ProxyProtocol != 0 {
+		dial = proxyProtocolDialer(upstream.ProxyProtocol, dial)
+	}
+
+	transport := &http.Transport{
+		Protocols:             backendProtocols(upstream.BackendProtocol),
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		DisableKeepAlives:     upstream.ProxyProtocol != 0,
+		MaxIdleConns:          maxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: upstream.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	// the upstreamManager rejects upstreams whose TLS settings can't be
+	// loaded, but their files may have changed since. When they can't be
+	// loaded, connections to its https backends fail with the error rather
+	// than falling back to the default settings
+	tlsConfig, err := buildBackendTLSConfig(upstream.BackendTLS)
+	if err != nil {
+		log.Println(err)
+		transport.DialTLSContext = func(context.Context, string, string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport
+}
+
+// upstreamConnectTimeout returns the upstream's connect timeout, falling back
+// to the configured default and then to defaultConnectTimeout
+func upstreamConnectTimeout(upstream *gatekeeper.Upstream, configured time.Duration) time.Duration {
+	if upstream.ConnectTimeout != 0 {
+		return upstream.ConnectTimeout
+	}
+	if configured != 0 {
+		return configured
+	}
+	return defaultConnectTimeout
+}
+
+// backendProtocols returns the HTTP versions used to connect to an upstream's
+// backends
+func backendProtocols(backendProtocol gatekeeper.BackendProtocol) *http.Protocols {
+	protocols := new(http.Protocols)
+
+	switch backendProtocol {
+	case gatekeeper.H2BackendProtocol:
+		protocols.SetHTTP2(true)
+	case gatekeeper.H2CBackendProtocol:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+
+	return protocols
+}
+
+// dialContext returns a dial function which connects with the given connect
+// timeout. When dnsTimeout is non-zero, the hostname is resolved separately
+// within dnsTimeout and each of its addresses dialed in turn, each with the
+// full connect timeout.
+func dialContext(connectTimeout, dnsTimeout time.Duration) func(context.Context, string, string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || dnsTimeout == 0 || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		for _, addr := range addrs {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+
+		return nil, err
+	}
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestTransportManagerTransport_onlyKeepsKnownUpstreams(t *testing.T) {
+	manager := NewTransportManager(NewBroadcaster(), Options{}).(*transportManager)
+	upstream := &gatekeeper.Upstream{ID: "billing"}
+	event := &UpstreamEvent{Upstream: upstream, UpstreamID: upstream.ID}
+
+	if manager.Transport(upstream) == manager.Transport(upstream) || len(manager.transports) != 0 {
+		t.Fatal("expected transports for unknown upstreams not to be kept")
+	}
+
+	manager.addUpstreamHook(event)
+	if manager.Transport(upstream) != manager.Transport(upstream) {
+		t.Fatal("expected the added upstream's transport to be kept")
+	}
+
+	// requests still in flight for a removed upstream don't bring its
+	// transport back
+	manager.removeUpstreamHook(event)
+	manager.Transport(upstream)
+	if len(manager.transports) != 0 || len(manager.settings) != 0 {
+		t.Fatalf("expected the removed upstream's transport to be dropped, got %v", manager.transports)
+	}
+}
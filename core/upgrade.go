@@ -0,0 +1,239 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// During an upgrade, the parent process passes its listening sockets to the
+// child as extra files, starting at fd 3. The listenerFDsEnv variable lists
+// the key of each listener in fd order, and readyFDEnv names the fd of a pipe
+// which the child writes to once it has started.
+const (
+	listenerFDsEnv = "GATEKEEPER_LISTENER_FDS"
+	readyFDEnv     = "GATEKEEPER_READY_FD"
+)
+
+// The listeners of TCP upstreams are keyed apart from the servers' listeners,
+// as they are claimed by the TCPProxy as its upstreams are added, rather than
+// by address when the servers are built.
+const tcpUpstreamKeyPrefix = "upstream-tcp:"
+
+func listenerKey(config ListenerConfig) string {
+	if config.Protocol == gatekeeper.TCP {
+		return tcpUpstreamKeyPrefix + config.Address
+	}
+	return config.Network + ":" + config.Address
+}
+
+// inheritance holds the listeners and ready pipe passed to this process by a
+// parent gatekeeper process during an upgrade.
+type inheritance struct {
+	loaded    bool
+	listeners map[string]net.Listener
+	readyFile *os.File
+
+	sync.Mutex
+}
+
+var inherited = &inheritance{
+	listeners: make(map[string]net.Listener),
+}
+
+// load parses the inherited file descriptors out of the environment. This
+// must happen before any plugin processes are started, as the inherited
+// descriptors are not close-on-exec and would otherwise leak into them.
+func (i *inheritance) load() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.loaded {
+		return nil
+	}
+	i.loaded = true
+
+	keys := os.Getenv(listenerFDsEnv)
+	readyFD := os.Getenv(readyFDEnv)
+	os.Unsetenv(listenerFDsEnv)
+	os.Unsetenv(readyFDEnv)
+
+	if keys != "" {
+		for idx, key := range strings.Split(keys, ",") {
+			file := os.NewFile(uintptr(3+idx), key)
+
+			// net.FileListener dups the descriptor, so the
+			// original can be closed once the listener is built
+			listener, err := net.FileListener(file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			i.listeners[key] = listener
+		}
+	}
+
+	if readyFD != "" {
+		fd, err := strconv.Atoi(readyFD)
+		if err != nil {
+			return err
+		}
+		syscall.CloseOnExec(fd)
+		i.readyFile = os.NewFile(uintptr(fd), "ready")
+	}
+
+	return nil
+}
+
+// take returns the inherited listener for the config, if there is one
+func (i *inheritance) take(config ListenerConfig) (net.Listener, bool) {
+	i.Lock()
+	defer i.Unlock()
+
+	listener, ok := i.listeners[listenerKey(config)]
+	delete(i.listeners, listenerKey(config))
+	return listener, ok
+}
+
+// takeTCPUpstreams returns the inherited listeners of TCP upstreams, by
+// address
+func (i *inheritance) takeTCPUpstreams() map[string]net.Listener {
+	i.Lock()
+	defer i.Unlock()
+
+	listeners := make(map[string]net.Listener)
+	for key, listener := range i.listeners {
+		if strings.HasPrefix(key, tcpUpstreamKeyPrefix) {
+			listeners[strings.TrimPrefix(key, tcpUpstreamKeyPrefix)] = listener
+			delete(i.listeners, key)
+		}
+	}
+	return listeners
+}
+
+// finish notifies the parent process that this process is ready and closes
+// any inherited listeners which no longer correspond to a configured listener.
+func (i *inheritance) finish() error {
+	i.Lock()
+	defer i.Unlock()
+
+	for key, listener := range i.listeners {
+		listener.Close()
+		delete(i.listeners, key)
+	}
+
+	if i.readyFile == nil {
+		return nil
+	}
+
+	_, err := i.readyFile.Write([]byte{1})
+	i.readyFile.Close()
+	i.readyFile = nil
+	return err
+}
+
+// upgradeListener is implemented by anything owning a listening socket which
+// is handed to the new process during an upgrade
+type upgradeListener interface {
+	ListenerConfig() ListenerConfig
+	ListenerFile() (*os.File, error)
+
+	// ListenerHandedOff is called once the new process is ready, after
+	// which the socket belongs to it; closing the listener must then leave
+	// a unix socket's path in place.
+	ListenerHandedOff()
+}
+
+// upgrade execs a new copy of this binary, with the same arguments, passing
+// it the given listening sockets. It returns once the child reports that it
+// has started, or an error if it fails to within timeout.
+func upgrade(servers []upgradeListener, timeout time.Duration) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, len(servers))
+	keys := make([]string, 0, len(servers))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for _, server := range servers {
+		file, err := server.ListenerFile()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+		keys = append(keys, listenerKey(server.ListenerConfig()))
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	// pass along the environment, apart from any upgrade variables this
+	// process was started with
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, value := range os.Environ() {
+		if !strings.HasPrefix(value, listenerFDsEnv+"=") && !strings.HasPrefix(value, readyFDEnv+"=") {
+			env = append(env, value)
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("%s=%s", listenerFDsEnv, strings.Join(keys, ",")),
+		fmt.Sprintf("%s=%d", readyFDEnv, 3+len(files)),
+	)
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = append(files, readyWriter)
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// the read returns once the child writes to the pipe, or with EOF
+	// when the child exits without becoming ready
+	readyCh := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		readyCh <- err
+	}()
+
+	select {
+	case err := <-readyCh:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, UpgradeFailedError
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, UpgradeTimeoutError
+	}
+
+	for _, server := range servers {
+		server.ListenerHandedOff()
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+// upgradeChildEnv makes the test binary act as the new process of an upgrade
+// when upgrade execs it, rather than running the tests again
+const upgradeChildEnv = "GATEKEEPER_TEST_UPGRADE_CHILD"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(upgradeChildEnv); mode != "" {
+		upgradeChild(mode)
+	}
+	os.Exit(m.Run())
+}
+
+// upgradeChild loads the inherited listeners, and then either reports that it
+// is ready and answers one connection on each listener with its key, exits
+// without becoming ready or never becomes ready at all
+func upgradeChild(mode string) {
+	if err := inherited.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch mode {
+	case "exit":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Hour)
+	}
+
+	listeners := make(map[string]net.Listener)
+	inherited.Lock()
+	for key, listener := range inherited.listeners {
+		listeners[key] = listener
+		delete(inherited.listeners, key)
+	}
+	inherited.Unlock()
+
+	if err := inherited.finish(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var wg sync.WaitGroup
+	for key, listener := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := listener.Accept()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			io.WriteString(conn, key)
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	os.Exit(0)
+}
+
+type testUpgradeListener struct {
+	config    ListenerConfig
+	listener  net.Listener
+	handedOff bool
+}
+
+func (l *testUpgradeListener) ListenerConfig() ListenerConfig  { return l.config }
+func (l *testUpgradeListener) ListenerFile() (*os.File, error) { return listenerFile(l.listener) }
+func (l *testUpgradeListener) ListenerHandedOff() {
+	l.handedOff = true
+	keepSocketPath(l.listener)
+}
+
+// newTestUpgradeListeners returns a server listener, a unix server listener
+// and a TCP upstream listener
+func newTestUpgradeListeners(t *testing.T) []*testUpgradeListener {
+	configs := []ListenerConfig{
+		{Protocol: gatekeeper.HTTPPublic, Network: "tcp", Address: "127.0.0.1:0"},
+		{Protocol: gatekeeper.HTTPInternal, Network: "unix", Address: filepath.Join(t.TempDir(), "gatekeeper.sock")},
+		{Protocol: gatekeeper.TCP, Network: "tcp", Address: "127.0.0.1:0"},
+	}
+
+	listeners := make([]*testUpgradeListener, 0, len(configs))
+	for _, config := range configs {
+		listener, err := net.Listen(config.Network, config.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+
+		config.Address = listener.Addr().String()
+		listeners = append(listeners, &testUpgradeListener{config: config, listener: listener})
+	}
+	return listeners
+}
+
+func asUpgradeListeners(listeners []*testUpgradeListener) []upgradeListener {
+	upgradeListeners := make([]upgradeListener, 0, len(listeners))
+	for _, listener := range listeners {
+		upgradeListeners = append(upgradeListeners, listener)
+	}
+	return upgradeListeners
+}
+
+func TestUpgrade_handsListenersToNewProcess(t *testing.T) {
+	t.Setenv(upgradeChildEnv, "ready")
+	listeners := newTestUpgradeListeners(t)
+
+	process, err := upgrade(asUpgradeListeners(listeners), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new process answers on each socket with the key it was passed
+	for _, listener := range listeners {
+		conn, err := net.Dial(listener.config.Network, listener.config.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _ := io.ReadAll(conn)
+		conn.Close()
+
+		if string(key) != listenerKey(listener.config) {
+			t.Fatalf("expected %s, got %q", listenerKey(listener.config), key)
+		}
+		if !listener.handedOff {
+			t.Fatalf("%s: expected the listener to be handed off", listener.config)
+		}
+	}
+
+	if state, err := process.Wait(); err != nil || !state.Success() {
+		t.Fatalf("expected the new process to exit cleanly, got %v %v", state, err)
+	}
+
+	// the unix socket's path belongs to the new process, so closing this
+	// process' listener leaves it in place
+	listeners[1].listener.Close()
+	if _, err := os.Stat(listeners[1].config.Address); err != nil {
+		t.Fatalf("expected the socket path to be kept, got %v", err)
+	}
+}
+
+func TestUpgrade_keepsServingWhenNewProcessFails(t *testing.T) {
+	testCases := []struct {
+		mode string
+		err  error
+	}{
+		{"exit", UpgradeFailedError},
+		{"hang", UpgradeTimeoutError},
+	}
+
+	for _, testCase := range testCases {
+		t.Setenv(upgradeChildEnv, testCase.mode)
+		listeners := newTestUpgradeListeners(t)
+
+		if _, err := upgrade(asUpgradeListeners(listeners), 500*time.Millisecond); err != testCase.err {
+			t.Fatalf("%s: expected %v, got %v", testCase.mode, testCase.err, err)
+		}
+
+		// the listeners still belong to this process, which keeps
+		// accepting connections on them
+		for _, listener := range listeners {
+			if listener.handedOff {
+				t.Fatalf("%s: expected %s not to be handed off", testCase.mode, listener.config)
+			}
+
+			conn, err := net.Dial(listener.config.Network, listener.config.Address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			accepted, err := listener.listener.Accept()
+			if err != nil {
+				t.Fatalf("%s: expected %s to accept, got %v", testCase.mode, listener.config, err)
+			}
+			accepted.Close()
+			conn.Close()
+		}
+	}
+}
+
+func TestInheritance_claimsListenersByKey(t *testing.T) {
+	newListener := func() net.Listener {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		return listener
+	}
+
+	server, upstream, unclaimed := newListener(), newListener(), newListener()
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readyReader.Close()
+
+	i := &inheritance{
+		listeners: map[string]net.Listener{
+			"tcp:127.0.0.1:8000":            server,
+			"upstream-tcp:127.0.0.1:8000":   upstream,
+			"unix:/var/run/gatekeeper.sock": unclaimed,
+		},
+		readyFile: readyWriter,
+	}
+
+	// a TCP upstream on the same address as a server has its own key
+	if listener, ok := i.take(ListenerConfig{Protocol: gatekeeper.HTTPPublic, Network: "tcp", Address: "127.0.0.1:8000"}); !ok || listener != server {
+		t.Fatalf("expected the server's listener, got %v", listener)
+	}
+	if _, ok := i.take(ListenerConfig{Protocol: gatekeeper.HTTPPublic, Network: "tcp", Address: "127.0.0.1:8000"}); ok {
+		t.Fatal("expected a listener to only be claimed once")
+	}
+	if listeners := i.takeTCPUpstreams(); len(listeners) != 1 || listeners["127.0.0.1:8000"] != upstream {
+		t.Fatalf("expected the upstream's listener by address, got %v", listeners)
+	}
+
+	// finishing closes the unclaimed listeners and tells the parent this
+	// process is ready
+	if err := i.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unclaimed.Accept(); err == nil {
+		t.Fatal("expected the unclaimed listener to be closed")
+	}
+	if ready, err := io.ReadAll(readyReader); err != nil || string(ready) != "\x01" {
+		t.Fatalf("expected the ready byte, got %q %v", ready, err)
+	}
+}
+
+func TestTCPProxyStart_closesUnclaimedInheritedListeners(t *testing.T) {
+	claimed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unclaimed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherited.Lock()
+	inherited.listeners[tcpUpstreamKeyPrefix+claimed.Addr().String()] = claimed
+	inherited.listeners[tcpUpstreamKeyPrefix+unclaimed.Addr().String()] = unclaimed
+	inherited.Unlock()
+
+	proxy := NewTCPProxy(NewBroadcaster(), Options{
+		TCPListenHost:  "127.0.0.1",
+		UpgradeTimeout: 100 * time.Millisecond,
+	}, testLoadBalancer{}, NewMetricWriter(1, 0)).(*tcpProxy)
+	if err := proxy.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Stop(0)
+
+	upstream := &gatekeeper.Upstream{
+		ID:        "claimed",
+		Protocols: []gatekeeper.Protocol{gatekeeper.TCP},
+		Port:      uint(claimed.Addr().(*net.TCPAddr).Port),
+	}
+	proxy.addUpstreamHook(&UpstreamEvent{Upstream: upstream, UpstreamID: upstream.ID})
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := net.Dial("tcp", unclaimed.Addr().String()); err == nil {
+		t.Fatal("expected the unclaimed listener to be closed after the upgrade timeout")
+	}
+	conn, err := net.Dial("tcp", claimed.Addr().String())
+	if err != nil {
+		t.Fatalf("expected the claimed listener to keep accepting, got %v", err)
+	}
+	conn.Close()
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"log"
 	"net/url"
 	"time"
@@ -13,6 +14,15 @@ type UpstreamManager interface {
 	starter
 	stopper
 	upstream_plugin.Manager
+
+	// query methods, returning the currently registered upstreams and
+	// the backends for an upstream
+	Upstreams() []*gatekeeper.Upstream
+	Backends(gatekeeper.UpstreamID) []*gatekeeper.Backend
+
+	// SetUpstreamWeight changes the weight of an upstream in a traffic
+	// split, leaving its backends and their connections as they are
+	SetUpstreamWeight(gatekeeper.UpstreamID, uint) error
 }
 
 func NewUpstreamManager(broadcaster Broadcaster, metricWriter MetricWriterClient) UpstreamManager {
@@ -20,6 +30,7 @@ func NewUpstreamManager(broadcaster Broadcaster, metricWriter MetricWriterClient
 		broadcaster: broadcaster,
 
 		upstreams:        make(map[gatekeeper.UpstreamID]*gatekeeper.Upstream),
+		routes:           make(map[string][]gatekeeper.UpstreamID),
 		backends:         make(map[gatekeeper.BackendID]*gatekeeper.Backend),
 		backendUpstreams: make(map[gatekeeper.BackendID]gatekeeper.UpstreamID),
 
@@ -34,6 +45,10 @@ type upstreamManager struct {
 	backends         map[gatekeeper.BackendID]*gatekeeper.Backend
 	backendUpstreams map[gatekeeper.BackendID]gatekeeper.UpstreamID
 
+	// the upstreams claiming each prefix and hostname, used to reject
+	// upstreams whose routes conflict
+	routes map[string][]gatekeeper.UpstreamID
+
 	metricWriter MetricWriterClient
 
 	RWMutex
@@ -51,9 +66,58 @@ func (m *upstreamManager) AddUpstream(upstream *gatekeeper.Upstream) error {
 		return DuplicateUpstreamErr
 	}
 
+	for _, hostname := range upstream.Hostnames {
+		if _, _, err := gatekeeper.ParseHostname(hostname); err != nil {
+			return err
+		}
+	}
+	if _, err := compileRules(upstream.Rules); err != nil {
+		return err
+	}
+	if _, err := buildBackendTLSConfig(upstream.BackendTLS); err != nil {
+		return fmt.Errorf("%w: %s", InvalidBackendTLSErr, err)
+	}
+	if err := m.checkRouteConflicts(upstream); err != nil {
+		return err
+	}
+
+	if ok {
+		m.unindexRoutes(existing)
+	}
+	m.indexRoutes(upstream)
+	m.publishUpstream(upstream)
+	return nil
+}
+
+// SetUpstreamWeight re-adds the upstream with the given weight, so that the
+// router splits requests by it; the upstream's transport, and therefore its
+// connections to its backends, are kept, since none of its other settings
+// change.
+func (m *upstreamManager) SetUpstreamWeight(upstreamID gatekeeper.UpstreamID, weight uint) error {
+	m.Lock()
+	defer m.Unlock()
+
+	existing, ok := m.upstreams[upstreamID]
+	if !ok {
+		return UpstreamNotFoundError
+	}
+	if existing.Split == "" {
+		return UpstreamNotInSplitErr
+	}
+
+	// upstreams are shared with the rest of the app, so a copy is updated
+	// rather than the upstream itself
+	upstream := *existing
+	upstream.Weight = weight
+	m.publishUpstream(&upstream)
+	return nil
+}
+
+// publishUpstream stores the upstream and emits events to the internal and
+// metric-writer pipelines
+func (m *upstreamManager) publishUpstream(upstream *gatekeeper.Upstream) {
 	m.upstreams[upstream.ID] = upstream
 
-	// emit events to the internal and metric-writer pipelines
 	m.eventMetric(gatekeeper.UpstreamAddedEvent)
 	m.upstreamMetric(gatekeeper.UpstreamAddedEvent, upstream, nil)
 	m.broadcaster.Publish(&UpstreamEvent{
@@ -61,9 +125,98 @@ func (m *upstreamManager) AddUpstream(upstream *gatekeeper.Upstream) error {
 		Upstream:   upstream,
 		UpstreamID: upstream.ID,
 	})
+}
+
+// checkRouteConflicts returns a RouteConflictErr when the upstream claims a
+// prefix or hostname which another upstream exposed on one of the same
+// protocols already claims, unless either has Rules to tell their requests
+// apart or both are in the same traffic split.
+func (m *upstreamManager) checkRouteConflicts(upstream *gatekeeper.Upstream) error {
+	if len(upstream.Rules) > 0 {
+		return nil
+	}
+
+	for _, route := range upstreamRoutes(upstream) {
+		for _, upstreamID := range m.routes[route] {
+			other := m.upstreams[upstreamID]
+			if other.ID == upstream.ID || len(other.Rules) > 0 {
+				continue
+			}
+			if upstream.Split != "" && upstream.Split == other.Split {
+				continue
+			}
+			if !sharesProtocol(upstream, other) {
+				continue
+			}
+
+			return fmt.Errorf("%w: %s is claimed by %s", RouteConflictErr, route, other.ID)
+		}
+	}
+
 	return nil
 }
 
+func (m *upstreamManager) indexRoutes(upstream *gatekeeper.Upstream) {
+	for _, route := range upstreamRoutes(upstream) {
+		m.routes[route] = append(m.routes[route], upstream.ID)
+	}
+}
+
+func (m *upstreamManager) unindexRoutes(upstream *gatekeeper.Upstream) {
+	for _, route := range upstreamRoutes(upstream) {
+		upstreamIDs := make([]gatekeeper.UpstreamID, 0, len(m.routes[route]))
+		for _, upstreamID := range m.routes[route] {
+			if upstreamID != upstream.ID {
+				upstreamIDs = append(upstreamIDs, upstreamID)
+			}
+		}
+
+		if len(upstreamIDs) == 0 {
+			delete(m.routes, route)
+		} else {
+			m.routes[route] = upstreamIDs
+		}
+	}
+}
+
+// upstreamRoutes returns the prefixes and hostnames an upstream claims, in the
+// form the router matches them, such as `prefix /api` for the `api/` prefix
+// and `hostname *.example.com` for a wildcard hostname
+func upstreamRoutes(upstream *gatekeeper.Upstream) []string {
+	routes := make([]string, 0, len(upstream.Prefixes)+len(upstream.Hostnames))
+	for _, prefix := range upstream.Prefixes {
+		if key, ok := prefixKey(prefix); ok {
+			routes = append(routes, "prefix "+key)
+		}
+	}
+
+	for _, hostname := range upstream.Hostnames {
+		typ, pattern, err := gatekeeper.ParseHostname(hostname)
+		if err != nil {
+			continue
+		}
+
+		switch typ {
+		case gatekeeper.WildcardHostname:
+			pattern = "*" + pattern
+		case gatekeeper.RegexHostname:
+			pattern = "~" + pattern
+		}
+		routes = append(routes, "hostname "+pattern)
+	}
+
+	return routes
+}
+
+func sharesProtocol(upstream, other *gatekeeper.Upstream) bool {
+	for _, protocol := range upstream.Protocols {
+		if other.HasProtocol(protocol) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *upstreamManager) RemoveUpstream(upstreamID gatekeeper.UpstreamID) error {
 	m.Lock()
 	defer m.Unlock()
@@ -74,6 +227,7 @@ func (m *upstreamManager) RemoveUpstream(upstreamID gatekeeper.UpstreamID) error
 	}
 
 	delete(m.upstreams, upstreamID)
+	m.unindexRoutes(upstream)
 
 	m.eventMetric(gatekeeper.UpstreamRemovedEvent)
 	m.upstreamMetric(gatekeeper.UpstreamRemovedEvent, upstream, nil)
@@ -101,8 +255,11 @@ func (m *upstreamManager) AddBackend(upstreamID gatekeeper.UpstreamID, backend *
 		return DuplicateBackendErr
 	}
 
+	// backends of tcp upstreams may also be given as host:port pairs
 	if _, err := url.Parse(backend.Address); err != nil {
-		return BackendAddressErr
+		if _, err := tcpBackendAddress(backend.Address); err != nil || !upstream.HasProtocol(gatekeeper.TCP) {
+			return BackendAddressErr
+		}
 	}
 
 	m.backends[backend.ID] = backend
@@ -138,6 +295,7 @@ func (m *upstreamManager) RemoveBackend(backendID gatekeeper.BackendID) error {
 	}
 
 	delete(m.backends, backendID)
+	delete(m.backendUpstreams, backendID)
 
 	m.eventMetric(gatekeeper.BackendRemovedEvent)
 	m.upstreamMetric(gatekeeper.BackendRemovedEvent, upstream, backend)
@@ -152,6 +310,30 @@ func (m *upstreamManager) RemoveBackend(backendID gatekeeper.BackendID) error {
 	return nil
 }
 
+func (m *upstreamManager) Upstreams() []*gatekeeper.Upstream {
+	m.RLock()
+	defer m.RUnlock()
+
+	upstreams := make([]*gatekeeper.Upstream, 0, len(m.upstreams))
+	for _, upstream := range m.upstreams {
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams
+}
+
+func (m *upstreamManager) Backends(upstreamID gatekeeper.UpstreamID) []*gatekeeper.Backend {
+	m.RLock()
+	defer m.RUnlock()
+
+	backends := make([]*gatekeeper.Backend, 0)
+	for backendID, backendUpstreamID := range m.backendUpstreams {
+		if backendUpstreamID == upstreamID {
+			backends = append(backends, m.backends[backendID])
+		}
+	}
+	return backends
+}
+
 func (p *upstreamManager) eventMetric(event gatekeeper.Event) {
 	p.metricWriter.EventMetric(&gatekeeper.EventMetric{
 		Timestamp: time.Now(),
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
+)
+
+func TestUpstreamManagerAddUpstream_rejectsRouteConflicts(t *testing.T) {
+	manager := NewUpstreamManager(NewBroadcaster(), NewMetricWriter(10, time.Second))
+
+	public := []gatekeeper.Protocol{gatekeeper.HTTPPublic}
+	internal := []gatekeeper.Protocol{gatekeeper.HTTPInternal}
+	rules := []gatekeeper.Rule{{Methods: []string{"POST"}}}
+
+	testCases := []struct {
+		upstream *gatekeeper.Upstream
+		conflict bool
+	}{
+		{&gatekeeper.Upstream{ID: "billing", Protocols: public, Prefixes: []string{"billing"}, Hostnames: []string{"*.example.com"}}, false},
+		{&gatekeeper.Upstream{ID: "billing-copy", Protocols: public, Prefixes: []string{"/billing/"}}, true},
+		{&gatekeeper.Upstream{ID: "wildcard", Protocols: public, Hostnames: []string{"*.Example.com"}}, true},
+		{&gatekeeper.Upstream{ID: "billing-internal", Protocols: internal, Prefixes: []string{"billing"}}, false},
+		{&gatekeeper.Upstream{ID: "billing-posts", Protocols: public, Prefixes: []string{"billing"}, Rules: rules}, false},
+
+		{&gatekeeper.Upstream{ID: "billing-v1", Protocols: public, Prefixes: []string{"billing/v1"}, Split: "billing", Weight: 95}, false},
+		{&gatekeeper.Upstream{ID: "billing-v2", Protocols: public, Prefixes: []string{"billing/v1"}, Split: "billing", Weight: 5}, false},
+		{&gatekeeper.Upstream{ID: "billing-v3", Protocols: public, Prefixes: []string{"billing/v1"}, Split: "other"}, true},
+
+		// re-adding an upstream doesn't conflict with itself
+		{&gatekeeper.Upstream{ID: "billing", Protocols: public, Prefixes: []string{"billing"}}, false},
+	}
+
+	for _, testCase := range testCases {
+		err := manager.AddUpstream(testCase.upstream)
+		if conflict := errors.Is(err, RouteConflictErr); conflict != testCase.conflict {
+			t.Fatalf("%s: expected conflict %t, got %v", testCase.upstream.ID, testCase.conflict, err)
+		}
+		if !testCase.conflict && err != nil {
+			t.Fatalf("%s: unexpected error %v", testCase.upstream.ID, err)
+		}
+	}
+
+	// the re-added billing upstream no longer claims the wildcard
+	if err := manager.AddUpstream(&gatekeeper.Upstream{ID: "wildcard", Protocols: public, Hostnames: []string{"*.example.com"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := manager.SetUpstreamWeight("billing-v2", 50); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := manager.SetUpstreamWeight("billing", 50); err != UpstreamNotInSplitErr {
+		t.Fatalf("expected UpstreamNotInSplitErr, got %v", err)
+	}
+}
+
+func TestUpstreamManagerAddUpstream_rejectsInvalidBackendTLS(t *testing.T) {
+	manager := NewUpstreamManager(NewBroadcaster(), NewMetricWriter(10, time.Second))
+
+	for _, backendTLS := range []gatekeeper.BackendTLS{
+		{CAFile: "/nonexistent/ca.pem"},
+		{CertFile: "/nonexistent/client.pem"},
+	} {
+		err := manager.AddUpstream(&gatekeeper.Upstream{ID: "billing", Prefixes: []string{"billing"}, BackendTLS: backendTLS})
+		if !errors.Is(err, InvalidBackendTLSErr) {
+			t.Fatalf("%+v: expected InvalidBackendTLSErr, got %v", backendTLS, err)
+		}
+	}
+
+	if upstreams := manager.Upstreams(); len(upstreams) != 0 {
+		t.Fatalf("expected no upstreams, got %v", upstreams)
+	}
+}
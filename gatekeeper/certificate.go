@@ -0,0 +1,59 @@
+package gatekeeper
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
+// ClientCertificate describes a client certificate which was verified against
+// the configured client CA bundle. It is RPC safe, so that router and modifier
+// plugins are able to make authorization decisions with it.
+type ClientCertificate struct {
+	Subject      string
+	Issuer       string
+	SerialNumber string
+
+	// subject alternative names
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []string
+	URIs           []string
+
+	// hex encoded SHA-256 fingerprint of the DER encoded certificate
+	Fingerprint string
+
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func NewClientCertificate(cert *x509.Certificate) *ClientCertificate {
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	ipAddresses := make([]string, len(cert.IPAddresses))
+	for idx, ip := range cert.IPAddresses {
+		ipAddresses[idx] = ip.String()
+	}
+
+	uris := make([]string, len(cert.URIs))
+	for idx, uri := range cert.URIs {
+		uris[idx] = uri.String()
+	}
+
+	return &ClientCertificate{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    ipAddresses,
+		URIs:           uris,
+
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
@@ -55,7 +55,7 @@ var ProgrammingErrorFatal bool
 
 // ProgrammingError's should not happen in normal operations
 func ProgrammingError(msg string) {
-	err := fmt.Sprintf("programming error: ", msg)
+	err := fmt.Sprintf("programming error: %s", msg)
 	if ProgrammingErrorFatal {
 		log.Fatal(err)
 	} else {
@@ -7,6 +7,11 @@ var (
 	UpstreamNotFoundErr = errors.New("upstream not found")
 	BackendNotFoundErr  = errors.New("backend not found")
 	RouteNotFoundErr    = errors.New("route now found")
+
+	InvalidBackendProtocolErr = errors.New("invalid backend protocol")
+	InvalidClientAuthErr      = errors.New("invalid client auth policy")
+	InvalidHostnameErr        = errors.New("invalid hostname")
+	InvalidRuleErr            = errors.New("invalid rule")
 )
 
 // Plugin specific errors
@@ -37,6 +37,21 @@ const (
 
 	PluginHeartbeatNotOkEvent
 	PluginHeartbeatOkEvent
+
+	CertificateReloadedEvent
+	CertificateReloadErrorEvent
+
+	RouteNotExposedEvent
+
+	AppDrainingEvent
+	ServerDrainingEvent
+
+	AppUpgradedEvent
+	AppUpgradeErrorEvent
+
+	TCPListenerStartedEvent
+	TCPListenerStoppedEvent
+	TCPListenerErrorEvent
 )
 
 var eventMapping = map[Event]string{
@@ -69,6 +84,21 @@ var eventMapping = map[Event]string{
 
 	PluginHeartbeatNotOkEvent: "plugin.heartbeat_failure",
 	PluginHeartbeatOkEvent:    "plugin.hearbeat",
+
+	CertificateReloadedEvent:    "certificate.reloaded",
+	CertificateReloadErrorEvent: "certificate.reload_error",
+
+	RouteNotExposedEvent: "route.not_exposed",
+
+	AppDrainingEvent:    "app.draining",
+	ServerDrainingEvent: "server.draining",
+
+	AppUpgradedEvent:     "app.upgraded",
+	AppUpgradeErrorEvent: "app.upgrade_error",
+
+	TCPListenerStartedEvent: "tcp_listener.started",
+	TCPListenerStoppedEvent: "tcp_listener.stopped",
+	TCPListenerErrorEvent:   "tcp_listener.error",
 }
 
 func (m Event) String() string {
@@ -0,0 +1,169 @@
+package gatekeeper
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// An upstream's Hostnames are each one of:
+//
+//   - an exact hostname, such as `api.example.com`
+//   - a wildcard, such as `*.example.com`, which matches any subdomain of
+//     `example.com`, such as `acme.example.com` or `eu.acme.example.com`,
+//     but not `example.com` itself
+//   - a regular expression prefixed with `~`, such as
+//     `~(?P<customer>[a-z0-9-]+)\.example\.com`, which must match the whole
+//     hostname and whose captured groups are added to the request's Context
+//     by name, or by index when unnamed
+//
+// Hostnames are compared without their port and case insensitively. When more
+// than one upstream's hostnames match a request, exact hostnames take
+// precedence over wildcards, with longer wildcards taking precedence over
+// shorter ones, and wildcards take precedence over regular expressions, which
+// are tried in the order their upstreams were added.
+const (
+	wildcardHostnamePrefix = "*."
+	regexHostnamePrefix    = "~"
+)
+
+// HostnameType is the kind of pattern an upstream hostname is
+type HostnameType uint
+
+const (
+	ExactHostname HostnameType = iota
+	WildcardHostname
+	RegexHostname
+)
+
+// ParseHostname returns the type of the hostname pattern and its normalized
+// form; the lowercased hostname for exact hostnames, the lowercased suffix,
+// such as `.example.com`, for wildcards and the expression, anchored to match
+// the whole hostname, for regular expressions.
+func ParseHostname(hostname string) (HostnameType, string, error) {
+	switch {
+	case strings.HasPrefix(hostname, regexHostnamePrefix):
+		expr := "^(?:" + strings.TrimPrefix(hostname, regexHostnamePrefix) + ")$"
+		if _, err := lookupHostnameRegex(expr); err != nil {
+			return RegexHostname, "", fmt.Errorf("%w: %s: %s", InvalidHostnameErr, hostname, err)
+		}
+		return RegexHostname, expr, nil
+	case strings.HasPrefix(hostname, wildcardHostnamePrefix):
+		suffix := NormalizeHostname(strings.TrimPrefix(hostname, "*"))
+		if len(suffix) < 2 || strings.Contains(suffix, "*") {
+			return WildcardHostname, "", fmt.Errorf("%w: %s", InvalidHostnameErr, hostname)
+		}
+		return WildcardHostname, suffix, nil
+	}
+
+	if hostname == "" || strings.Contains(hostname, "*") {
+		return ExactHostname, "", fmt.Errorf("%w: %s", InvalidHostnameErr, hostname)
+	}
+	return ExactHostname, NormalizeHostname(hostname), nil
+}
+
+// NormalizeHostname lowercases a request's Host, removing its port and any
+// trailing dot
+func NormalizeHostname(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// WildcardSuffixes returns the suffixes which wildcards matching the hostname
+// have, from the longest to the shortest; `.b.example.com`, `.example.com` and
+// `.com` for `a.b.example.com`.
+func WildcardSuffixes(hostname string) []string {
+	var suffixes []string
+	for idx := 0; idx < len(hostname)-1; idx++ {
+		if hostname[idx] == '.' {
+			suffixes = append(suffixes, hostname[idx:])
+		}
+	}
+	return suffixes
+}
+
+// MatchHostname matches a request's Host against an upstream hostname,
+// returning the regular expression's captured groups for regex hostnames.
+func MatchHostname(hostname, host string) (bool, map[string]string) {
+	typ, pattern, err := ParseHostname(hostname)
+	if err != nil {
+		return false, nil
+	}
+
+	host = NormalizeHostname(host)
+	switch typ {
+	case WildcardHostname:
+		return strings.HasSuffix(host, pattern) && len(host) > len(pattern), nil
+	case RegexHostname:
+		regex, err := lookupHostnameRegex(pattern)
+		if err != nil {
+			return false, nil
+		}
+		return MatchHostnameRegex(regex, host)
+	}
+
+	return host == pattern, nil
+}
+
+// hostnameRegexes caches the regex hostnames compiled by CompileHostnameRegex,
+// keyed by their expression, until they are released
+var hostnameRegexes sync.Map
+
+// CompileHostnameRegex compiles the expression of a regex hostname, as
+// returned by ParseHostname, caching it until ReleaseHostnameRegex is called
+// with the expression
+func CompileHostnameRegex(expr string) (*regexp.Regexp, error) {
+	if regex, ok := hostnameRegexes.Load(expr); ok {
+		return regex.(*regexp.Regexp), nil
+	}
+
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	hostnameRegexes.Store(expr, regex)
+	return regex, nil
+}
+
+// ReleaseHostnameRegex removes the expression's compiled regex from the cache,
+// once no upstream claims it any longer
+func ReleaseHostnameRegex(expr string) {
+	hostnameRegexes.Delete(expr)
+}
+
+// lookupHostnameRegex returns the cached regex for the expression, compiling
+// it without caching it otherwise, so that hostnames which are only parsed or
+// matched, such as those of upstreams which failed validation, aren't kept
+func lookupHostnameRegex(expr string) (*regexp.Regexp, error) {
+	if regex, ok := hostnameRegexes.Load(expr); ok {
+		return regex.(*regexp.Regexp), nil
+	}
+	return regexp.Compile(expr)
+}
+
+// MatchHostnameRegex matches a normalized hostname against a regex hostname,
+// returning its captured groups, keyed by name or by index when unnamed
+func MatchHostnameRegex(regex *regexp.Regexp, hostname string) (bool, map[string]string) {
+	submatches := regex.FindStringSubmatch(hostname)
+	if submatches == nil {
+		return false, nil
+	}
+
+	captures := make(map[string]string, len(submatches)-1)
+	for idx, name := range regex.SubexpNames() {
+		if idx == 0 {
+			continue
+		}
+		if name == "" {
+			name = strconv.Itoa(idx)
+		}
+		captures[name] = submatches[idx]
+	}
+	return true, captures
+}
@@ -0,0 +1,79 @@
+package gatekeeper
+
+import "testing"
+
+func TestMatchHostname_matchesPatterns(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		host     string
+		match    bool
+		captures map[string]string
+	}{
+		{"api.example.com", "api.example.com", true, nil},
+		{"api.example.com", "API.example.com:8000", true, nil},
+		{"api.example.com", "api.example.com.", true, nil},
+		{"api.example.com", "www.example.com", false, nil},
+		{"*.example.com", "acme.example.com:443", true, nil},
+		{"*.example.com", "eu.acme.example.com", true, nil},
+		{"*.example.com", "example.com", false, nil},
+		{"*.example.com", "acmeexample.com", false, nil},
+		{`~^(?P<customer>[a-z0-9-]+)\.example\.com$`, "acme.example.com:8000", true, map[string]string{"customer": "acme"}},
+		{`~^([a-z]+)\.(eu|us)\.example\.com$`, "acme.eu.example.com", true, map[string]string{"1": "acme", "2": "eu"}},
+		{`~^([a-z]+)\.example\.com$`, "acme.example.org", false, nil},
+		{`~([a-z]+)\.example\.com`, "acme.example.com", true, map[string]string{"1": "acme"}},
+		{`~([a-z]+)\.example\.com`, "acme.example.com.evil.org", false, nil},
+		{`~([a-z]+)\.example\.com`, "evil.org.acme.example.com", false, nil},
+		{`~acme|example\.com`, "acme.evil.org", false, nil},
+		{"[::1]", "[::1]:8000", true, nil},
+	}
+
+	for _, testCase := range testCases {
+		match, captures := MatchHostname(testCase.hostname, testCase.host)
+		if match != testCase.match {
+			t.Fatalf("%s: expected match to be %t for %s", testCase.hostname, testCase.match, testCase.host)
+		}
+		for name, value := range testCase.captures {
+			if captures[name] != value {
+				t.Fatalf("%s: expected %s to capture %q, got %q", testCase.hostname, name, value, captures[name])
+			}
+		}
+	}
+}
+
+func TestParseHostname_rejectsInvalidPatterns(t *testing.T) {
+	for _, hostname := range []string{"", "*.", "api.*.example.com", "*.*.example.com", "~(unclosed"} {
+		if _, _, err := ParseHostname(hostname); err == nil {
+			t.Fatalf("expected %q to be invalid", hostname)
+		}
+	}
+}
+
+func TestCompileHostnameRegex_cachesUntilReleased(t *testing.T) {
+	_, expr, err := ParseHostname(`~([a-z]+)\.cached\.example\.com`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// parsing and matching hostnames doesn't cache them
+	MatchHostname(`~([a-z]+)\.cached\.example\.com`, "acme.cached.example.com")
+	if _, ok := hostnameRegexes.Load(expr); ok {
+		t.Fatal("expected matching not to cache the regex")
+	}
+
+	regex, err := CompileHostnameRegex(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := CompileHostnameRegex(expr); cached != regex {
+		t.Fatal("expected the compiled regex to be cached")
+	}
+
+	ReleaseHostnameRegex(expr)
+	if _, ok := hostnameRegexes.Load(expr); ok {
+		t.Fatal("expected the regex to be released")
+	}
+
+	if _, err := CompileHostnameRegex("^(?:(unclosed)$"); err == nil {
+		t.Fatal("expected an invalid expression to fail to compile")
+	}
+}
@@ -16,14 +16,16 @@ const (
 	PluginMetricType
 	RequestMetricType
 	UpstreamMetricType
+	ConnectionMetricType
 )
 
 var metricTypeMapping = map[MetricType]string{
-	EventMetricType:     "event metric",
-	ProfilingMetricType: "profiling metric",
-	PluginMetricType:    "plugin metric",
-	RequestMetricType:   "request metric",
-	UpstreamMetricType:  "upstream metric",
+	EventMetricType:      "event metric",
+	ProfilingMetricType:  "profiling metric",
+	PluginMetricType:     "plugin metric",
+	RequestMetricType:    "request metric",
+	UpstreamMetricType:   "upstream metric",
+	ConnectionMetricType: "connection metric",
 }
 
 func (m MetricType) String() string {
@@ -94,15 +96,24 @@ type RequestMetric struct {
 	Upstream *Upstream
 	Backend  *Backend
 
+	// Split is the name of the weighted traffic split which the upstream
+	// was chosen from, when it was chosen from one
+	Split string
+
 	RequestStartTS time.Time
 	RequestEndTS   time.Time
 
 	// Latencies
-	Latency           time.Duration
-	InternalLatency   time.Duration // total local latency, including
-	DNSLookupLatency  time.Duration
-	TCPConnectLatency time.Duration
-	ProxyLatency      time.Duration
+	Latency             time.Duration
+	InternalLatency     time.Duration // total local latency, including
+	DNSLookupLatency    time.Duration
+	TCPConnectLatency   time.Duration
+	TLSHandshakeLatency time.Duration
+	ProxyLatency        time.Duration
+
+	// time from the request being proxied until the first byte of the
+	// backend's response was read
+	TimeToFirstByte time.Duration
 
 	// Connection meta inforamtion
 	DNSLookup    bool
@@ -120,6 +131,37 @@ type RequestMetric struct {
 	// Any sort of error that could have been bubbled up throughout the
 	// request path
 	Error *Error
+
+	// the HTTP versions spoken with the client and the backend, such as
+	// HTTP/1.1 or HTTP/2.0
+	Proto        string
+	BackendProto string
+
+	// GRPC is true for gRPC requests, with GRPCStatus holding the
+	// grpc-status the client received
+	GRPC       bool
+	GRPCStatus int
+
+	// Streamed is true when the response was streamed to the client,
+	// rather than buffered
+	Streamed bool
+
+	// ClientCanceled is true when the client went away before the
+	// backend responded, canceling the proxied request
+	ClientCanceled bool
+
+	// BackendTLSHandshakeFailed is true when the request failed because
+	// the TLS handshake with the backend did, such as when its certificate
+	// isn't trusted or it rejected the client certificate
+	BackendTLSHandshakeFailed bool
+
+	// Upgraded is true when the request switched protocols, such as to a
+	// websocket. The connection was then open for UpgradeDuration, with
+	// the given number of bytes copied in each direction.
+	Upgraded         bool
+	UpgradeDuration  time.Duration
+	BytesFromClient  int64
+	BytesFromBackend int64
 }
 
 // UpstreamMetrics are useful for garnering granular metrics on particular
@@ -138,3 +180,37 @@ type UpstreamMetric struct {
 	Upstream *Upstream
 	Backend  *Backend
 }
+
+// ConnectionMetric describes a single connection proxied by a TCP upstream,
+// from being accepted until both the client and backend sides were closed.
+type ConnectionMetric struct {
+	Timestamp time.Time
+
+	Upstream *Upstream
+	Backend  *Backend
+
+	ClientAddress  string
+	ListenAddress  string
+	BackendAddress string
+
+	ConnectionStartTS time.Time
+	ConnectionEndTS   time.Time
+
+	// Latencies
+	Duration            time.Duration
+	LoadBalancerLatency time.Duration
+	ConnectLatency      time.Duration
+
+	// number of bytes copied in each direction
+	BytesFromClient  int64
+	BytesFromBackend int64
+
+	// ConnectError is true when no backend was found or the backend could
+	// not be connected to, in which case the client connection is closed
+	// without any data being copied.
+	ConnectError bool
+
+	// Any error raised while connecting to the backend or copying between
+	// the connections
+	Error *Error
+}
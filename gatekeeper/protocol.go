@@ -1,6 +1,7 @@
 package gatekeeper
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 )
@@ -12,6 +13,10 @@ const (
 	HTTPInternal
 	HTTPSPublic
 	HTTPSInternal
+
+	// TCP upstreams are proxied at the connection level, on a listener
+	// bound to the upstream's Port, rather than by an HTTP server
+	TCP
 )
 
 var formattedProtocols = map[Protocol]string{
@@ -19,6 +24,7 @@ var formattedProtocols = map[Protocol]string{
 	HTTPInternal:  "http-internal",
 	HTTPSPublic:   "https-public",
 	HTTPSInternal: "https-internal",
+	TCP:           "tcp",
 }
 
 func (p Protocol) String() string {
@@ -29,6 +35,35 @@ func (p Protocol) String() string {
 	return str
 }
 
+// MarshalJSON encodes the protocol as its string representation
+func (p Protocol) MarshalJSON() ([]byte, error) {
+	str, ok := formattedProtocols[p]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol")
+	}
+	return json.Marshal(str)
+}
+
+func (p *Protocol) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+
+	protocol, err := ParseProtocol(str)
+	if err != nil {
+		return err
+	}
+
+	*p = protocol
+	return nil
+}
+
+// IsTLS returns true for protocols which are served over TLS
+func (p Protocol) IsTLS() bool {
+	return p == HTTPSPublic || p == HTTPSInternal
+}
+
 func ParseProtocol(value string) (Protocol, error) {
 	for protocol, str := range formattedProtocols {
 		if str == value {
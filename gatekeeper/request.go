@@ -21,15 +21,31 @@ type Request struct {
 	Upstream *Upstream
 	// the mechanism with which the upstream was matched
 	UpstreamMatchType UpstreamMatchType
+	// the weighted traffic split which the upstream was chosen from, if any
+	Split string
 
 	// remote address of the caller
 	RemoteAddr string
 	Method     string
 
+	// ClientIP is the address of the client which made the request; the
+	// caller's address, unless it is a trusted proxy which forwarded the
+	// request on behalf of the client
+	ClientIP string
+
+	// the HTTP version the client is speaking, such as HTTP/1.1 or HTTP/2.0
+	Proto string
+
+	// ClientCertificate is the client's certificate, when it presented one
+	// which was verified against the client CA bundle
+	ClientCertificate *ClientCertificate
+
 	// request.Host or url.Host depending upon which is set
 	Host string
 
-	// the first component of the URL, specifically the prefix
+	// the prefix of the path which the upstream was matched by, such as
+	// `api/v2`, which is the first component of the path until the
+	// request is routed
 	Prefix         string
 	PrefixlessPath string
 
@@ -64,6 +80,7 @@ func NewRequest(req *http.Request, protocol Protocol) *Request {
 
 		RemoteAddr: req.RemoteAddr,
 		Method:     req.Method,
+		Proto:      req.Proto,
 
 		Host: req.Host,
 
@@ -82,6 +99,16 @@ func NewRequest(req *http.Request, protocol Protocol) *Request {
 	}
 }
 
+// SetPrefix records the upstream prefix which the request was matched by, such
+// as `api/v2`, setting its PrefixlessPath to the rest of the request's path.
+func (r *Request) SetPrefix(prefix string) {
+	r.Prefix = strings.Trim(prefix, "/")
+	r.PrefixlessPath = r.Path
+	if r.Prefix != "" {
+		r.PrefixlessPath = strings.TrimPrefix(r.Path, "/"+r.Prefix)
+	}
+}
+
 func (r *Request) AddError(err error) {
 	r.Error = NewError(err)
 }
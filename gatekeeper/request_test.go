@@ -0,0 +1,53 @@
+package gatekeeper
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestReqPrefix_findsPrefix(t *testing.T) {
+	testCases := []struct {
+		url    string
+		prefix string
+	}{
+		{"https://github.com/", ""},
+		{"https://github.com/foo", "foo"},
+		{"https://github.com/foo/", "foo"},
+		{"https://github.com/foo/bar", "foo"},
+		{"https://github.com?foo=bar", ""},
+	}
+
+	for _, testCase := range testCases {
+		url, _ := url.Parse(testCase.url)
+		req := &http.Request{
+			URL: url,
+		}
+		if ReqPrefix(req) != testCase.prefix {
+			t.Log(ReqPrefix(req))
+			t.Fatalf("did not parse prefix correctly")
+		}
+	}
+}
+
+func TestRequestSetPrefix_trimsPrefix(t *testing.T) {
+	testCases := []struct {
+		path           string
+		prefix         string
+		expectedPrefix string
+		prefixlessPath string
+	}{
+		{"/api/v2/billing/invoices", "/api/v2/billing", "api/v2/billing", "/invoices"},
+		{"/api/v2/billing", "api/v2/billing/", "api/v2/billing", ""},
+		{"/api/users", "api", "api", "/users"},
+		{"/users", "/", "", "/users"},
+	}
+
+	for _, testCase := range testCases {
+		req := &Request{Path: testCase.path}
+		req.SetPrefix(testCase.prefix)
+		if req.Prefix != testCase.expectedPrefix || req.PrefixlessPath != testCase.prefixlessPath {
+			t.Fatalf("expected prefix %q and path %q, got %q and %q", testCase.expectedPrefix, testCase.prefixlessPath, req.Prefix, req.PrefixlessPath)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package gatekeeper
+
+// Rule is a set of predicates on a request, all of which must hold for the
+// rule to match. An upstream with Rules is only matched by requests which
+// match at least one of them, and takes precedence over upstreams without
+// Rules which claim the same prefix or hostname.
+type Rule struct {
+	// Methods are the HTTP methods which the request's method must be one
+	// of, when set
+	Methods []string
+
+	// Headers, Query and Cookies match the request's headers, query
+	// parameters and cookies by name
+	Headers []FieldRule
+	Query   []FieldRule
+	Cookies []FieldRule
+}
+
+// FieldRule matches a header, query parameter or cookie. When neither Value
+// nor Regex is set, the field only has to be present; otherwise one of its
+// values has to equal Value and match the regular expression Regex, for
+// whichever are set.
+type FieldRule struct {
+	Name  string
+	Value string
+	Regex string
+}
@@ -22,6 +22,7 @@ const (
 	PrefixUpstreamMatch
 	HostnameUpstreamMatch
 	OtherUpstreamMatch
+	RuleUpstreamMatch
 )
 
 func (u UpstreamMatchType) String() string {
@@ -34,11 +35,112 @@ func (u UpstreamMatchType) String() string {
 		return "hostname_match"
 	case OtherUpstreamMatch:
 		return "other_match"
+	case RuleUpstreamMatch:
+		return "rule_match"
 	}
 
 	return ""
 }
 
+// BackendProtocol is the HTTP version an upstream's backends are spoken to
+// with; HTTP/1.1, HTTP/2 over TLS or HTTP/2 over cleartext (h2c)
+type BackendProtocol uint
+
+const (
+	HTTP1BackendProtocol BackendProtocol = iota
+	H2BackendProtocol
+	H2CBackendProtocol
+)
+
+var formattedBackendProtocols = map[BackendProtocol]string{
+	HTTP1BackendProtocol: "http1",
+	H2BackendProtocol:    "h2",
+	H2CBackendProtocol:   "h2c",
+}
+
+func (b BackendProtocol) String() string {
+	return formattedBackendProtocols[b]
+}
+
+// ParseBackendProtocol parses `http1`, `h2` or `h2c`, treating an empty
+// string as the default of `http1`
+func ParseBackendProtocol(value string) (BackendProtocol, error) {
+	if value == "" {
+		return HTTP1BackendProtocol, nil
+	}
+
+	for backendProtocol, str := range formattedBackendProtocols {
+		if str == value {
+			return backendProtocol, nil
+		}
+	}
+
+	return HTTP1BackendProtocol, InvalidBackendProtocolErr
+}
+
+// ClientAuth is an upstream's policy for the client certificates of its
+// requests, which are verified against the https-internal servers' client CA
+// bundle.
+type ClientAuth uint
+
+const (
+	// NoClientAuth doesn't check client certificates
+	NoClientAuth ClientAuth = iota
+	// OptionalClientAuth rejects requests with a certificate which failed
+	// verification, but allows requests without one
+	OptionalClientAuth
+	// RequireClientAuth rejects requests without a verified certificate
+	RequireClientAuth
+)
+
+var formattedClientAuths = map[ClientAuth]string{
+	NoClientAuth:       "none",
+	OptionalClientAuth: "optional",
+	RequireClientAuth:  "require",
+}
+
+func (c ClientAuth) String() string {
+	return formattedClientAuths[c]
+}
+
+// ParseClientAuth parses `none`, `optional` or `require`, treating an empty
+// string as the default of `none`
+func ParseClientAuth(value string) (ClientAuth, error) {
+	if value == "" {
+		return NoClientAuth, nil
+	}
+
+	for clientAuth, str := range formattedClientAuths {
+		if str == value {
+			return clientAuth, nil
+		}
+	}
+
+	return NoClientAuth, InvalidClientAuthErr
+}
+
+// BackendTLS configures the TLS connections to an upstream's https backends.
+// Files are read from the gatekeeper host when the upstream is added; an empty
+// BackendTLS verifies backends against the system roots.
+type BackendTLS struct {
+	// CAFile is a PEM bundle of the CAs which backend certificates are
+	// verified against, in place of the system roots
+	CAFile string
+
+	// CertFile and KeyFile are a PEM certificate and key presented to
+	// backends which require client certificates
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the hostname sent with SNI and verified against
+	// the backend's certificate, which is otherwise the backend's host
+	ServerName string
+
+	// InsecureSkipVerify disables verifying backend certificates, and
+	// should only be used in development
+	InsecureSkipVerify bool
+}
+
 type Upstream struct {
 	ID        UpstreamID
 	Name      string
@@ -47,11 +149,63 @@ type Upstream struct {
 	Prefixes  []string
 	Timeout   time.Duration
 	Extra     map[string]interface{}
+
+	// connection settings for the upstream's backends; each falls back to
+	// a default when zero
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// BackendProtocol is the HTTP version used to connect to backends,
+	// defaulting to HTTP/1.1. gRPC requests are rejected by upstreams
+	// with HTTP/1.1 backends, as gRPC statuses are sent in trailers.
+	BackendProtocol BackendProtocol
+
+	// Streaming upstreams flush responses to the client as they are read
+	// from the backend. Server-sent event and chunked responses are
+	// streamed regardless.
+	Streaming bool
+
+	// Port is the port which a TCP upstream's listener is bound to. Each
+	// connection accepted on it is proxied to one of the upstream's
+	// backends, whose addresses are host:port pairs.
+	Port uint
+
+	// ProxyProtocol is the version of the PROXY protocol header, 1 or 2,
+	// sent to backends at the start of each connection, carrying the
+	// client's address; zero disables it. Connections to the backends of
+	// HTTP upstreams which send it are not reused between requests.
+	ProxyProtocol uint
+
+	// ClientAuth is the policy for the client certificates of requests to
+	// the upstream, defaulting to not checking them
+	ClientAuth ClientAuth
+
+	// BackendTLS configures TLS for backends with https addresses
+	BackendTLS BackendTLS
+
+	// Rules narrow the requests which the upstream's prefixes and
+	// hostnames match, by method, header, query parameter or cookie.
+	// Upstreams with Rules and neither prefixes nor hostnames are matched
+	// by their Rules alone.
+	Rules []Rule
+
+	// Split is the name of the weighted traffic split the upstream is in,
+	// such as a canary release. Requests matching a prefix or hostname
+	// which upstreams in the same Split share are divided between them in
+	// proportion to their Weights, with upstreams whose Weight is zero
+	// receiving none of them.
+	Split  string
+	Weight uint
 }
 
+// HasHostname returns true when any of the upstream's hostnames match the
+// given Host, which may include a port
 func (u Upstream) HasHostname(name string) bool {
 	for _, hostname := range u.Hostnames {
-		if name == hostname {
+		if ok, _ := MatchHostname(hostname, name); ok {
 			return true
 		}
 	}
@@ -66,3 +220,12 @@ func (u Upstream) HasPrefix(name string) bool {
 	}
 	return false
 }
+
+func (u Upstream) HasProtocol(protocol Protocol) bool {
+	for _, p := range u.Protocols {
+		if protocol == p {
+			return true
+		}
+	}
+	return false
+}
@@ -8,11 +8,13 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
 	core "github.com/jonmorehouse/gatekeeper/core"
+	"github.com/jonmorehouse/gatekeeper/gatekeeper"
 )
 
 func flagSetToMap(set *flag.FlagSet) map[string]interface{} {
@@ -25,6 +27,16 @@ func flagSetToMap(set *flag.FlagSet) map[string]interface{} {
 	return vals
 }
 
+// splitList splits a comma-delimited flag value, treating an empty value as
+// an empty list
+func splitList(value string) []string {
+	if value == "" {
+		return []string(nil)
+	}
+
+	return strings.Split(value, ",")
+}
+
 func parseFlags(options *core.Options) error {
 	commandLine := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
@@ -43,43 +55,100 @@ func parseFlags(options *core.Options) error {
 	// server configuration
 	httpPublic := commandLine.Bool("http-public", true, "http-public enabled. default: true")
 	httpPublicPort := commandLine.Uint("http-public-port", 8000, "http-public listen port. default: 8000")
+	httpPublicListen := commandLine.String("http-public-listen", "", "comma-delimited http-public listen addresses, eg: 127.0.0.1:8000,[::1]:8000,unix:/tmp/gatekeeper.sock. default: :<http-public-port>")
 
 	httpInternal := commandLine.Bool("http-internal", false, "http-internal enabled. default: false")
 	httpInternalPort := commandLine.Uint("http-internal-port", 8001, "http-public listen port. default: 8001")
+	httpInternalListen := commandLine.String("http-internal-listen", "", "comma-delimited http-internal listen addresses. default: :<http-internal-port>")
 
 	httpsPublic := commandLine.Bool("https-public", false, "https-public enabled. default: false")
 	httpsPublicPort := commandLine.Uint("https-public-port", 443, "http-public listen port. default: 443")
+	httpsPublicListen := commandLine.String("https-public-listen", "", "comma-delimited https-public listen addresses. default: :<https-public-port>")
 
 	httpsInternal := commandLine.Bool("https-internal", false, "https-internal false")
 	httpsInternalPort := commandLine.Uint("https-internal-port", 444, "http-internal listen port. default: 444")
+	httpsInternalListen := commandLine.String("https-internal-listen", "", "comma-delimited https-internal listen addresses. default: :<https-internal-port>")
+
+	// tls configuration for the https servers
+	tlsCerts := commandLine.String("tls-cert", "", "comma-delimited certificate paths for the https servers")
+	tlsKeys := commandLine.String("tls-key", "", "comma-delimited private key paths, in the same order as tls-cert")
+	tlsMinVersion := commandLine.String("tls-min-version", "1.2", "minimum tls version for the https servers. default: 1.2")
+	tlsCipherSuites := commandLine.String("tls-cipher-suites", "", "comma-delimited tls cipher suites, including TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 when http2 is enabled. default: crypto/tls defaults")
+	http2 := commandLine.Bool("http2", true, "serve http/2 to https clients which negotiate it. default: true")
+	h2c := commandLine.Bool("h2c", false, "serve cleartext http/2 to http clients with prior knowledge. default: false")
+	tlsReloadInterval := commandLine.Duration("tls-reload-interval", 10*time.Second, "interval to check certificates for changes, 0 to disable. default: 10s")
+	tlsClientCA := commandLine.String("tls-client-ca", "", "CA bundle to verify the client certificates of https-internal requests against, enabling per-upstream client auth. default: disabled")
+
+	// tcp upstreams are each bound to their own port on this host
+	tcpListenHost := commandLine.String("tcp-listen-host", "", "host which tcp upstreams' listeners are bound to. default: all interfaces")
+
+	// listeners which sit behind a load balancer can accept PROXY protocol headers
+	proxyProtocol := commandLine.String("proxy-protocol", "", "comma-delimited protocols whose listeners accept PROXY protocol v1/v2 headers, eg: http-public,https-public,tcp. default: none")
+	proxyProtocolTrustedCIDRs := commandLine.String("proxy-protocol-trusted-cidrs", "", "comma-delimited networks which must send a PROXY protocol header, eg: 10.0.0.0/8. required with proxy-protocol")
+
+	// forwarding headers are only passed along from trusted proxies
+	trustedProxies := commandLine.String("trusted-proxies", "", "comma-delimited networks whose Forwarded and X-Forwarded-* headers are trusted, eg: 10.0.0.0/8. default: none")
 
 	// configure both a plugin and request timeout
 	pluginTimeout := commandLine.Duration("plugin-timeout", 10*time.Millisecond, "plugin call timeout. default 10ms")
 	proxyTimeout := commandLine.Duration("default-proxy-timeout", 5*time.Second, "default proxy request timeout. default 5s")
+	tcpConnectTimeout := commandLine.Duration("default-tcp-connect-timeout", 30*time.Second, "default backend connect timeout, overridden per upstream. default 30s")
+	dnsTimeout := commandLine.Duration("default-dns-timeout", 0, "backend hostname lookup timeout, 0 to only bound lookups by the connect timeout. default 0")
+
+	// the admin api, served on its own address and / or on http-internal
+	adminListen := commandLine.String("admin-listen", "", "admin api listen address, eg: 127.0.0.1:8002 or unix:/tmp/gatekeeper-admin.sock. default: disabled")
+	adminOnInternal := commandLine.Bool("admin-on-internal", false, "serve the admin api under /_gatekeeper on the internal servers. default: false")
+
+	// health checks, served on their own address as well as on the admin api
+	healthListen := commandLine.String("health-listen", "", "/healthz and /readyz listen address, eg: 127.0.0.1:8003 or unix:/tmp/gatekeeper-health.sock. default: disabled")
+
+	upgradeTimeout := commandLine.Duration("upgrade-timeout", 30*time.Second, "time to wait for an upgraded process to become ready. default: 30s")
 
 	metricBufferSize := commandLine.Uint("metric-buffer-size", 10000, "metric buffer size")
 	metricFlushInterval := commandLine.Duration("metrif-flush-interval", 100*time.Millisecond, "max interval between metric flushes")
 
 	knownFlags := map[string]struct{}{
-		"local-loadbalancer":    struct{}{},
-		"loadbalancer-plugin":   struct{}{},
-		"local-router":          struct{}{},
-		"router-plugin":         struct{}{},
-		"upstream-plugins":      struct{}{},
-		"metric-plugins":        struct{}{},
-		"modifier-plugins":      struct{}{},
-		"http-public":           struct{}{},
-		"http-public-port":      struct{}{},
-		"http-internal":         struct{}{},
-		"http-internal-port":    struct{}{},
-		"https-public":          struct{}{},
-		"https-public-port":     struct{}{},
-		"https-internal":        struct{}{},
-		"https-internal-port":   struct{}{},
-		"plugin-timeout":        struct{}{},
-		"proxy-timeout":         struct{}{},
-		"metric-buffer-size":    struct{}{},
-		"metric-flush-interval": struct{}{},
+		"local-loadbalancer":           struct{}{},
+		"loadbalancer-plugin":          struct{}{},
+		"local-router":                 struct{}{},
+		"router-plugin":                struct{}{},
+		"upstream-plugins":             struct{}{},
+		"metric-plugins":               struct{}{},
+		"modifier-plugins":             struct{}{},
+		"http-public":                  struct{}{},
+		"http-public-port":             struct{}{},
+		"http-public-listen":           struct{}{},
+		"http-internal":                struct{}{},
+		"http-internal-port":           struct{}{},
+		"http-internal-listen":         struct{}{},
+		"https-public":                 struct{}{},
+		"https-public-port":            struct{}{},
+		"https-public-listen":          struct{}{},
+		"https-internal":               struct{}{},
+		"https-internal-port":          struct{}{},
+		"https-internal-listen":        struct{}{},
+		"tls-cert":                     struct{}{},
+		"tls-key":                      struct{}{},
+		"tls-min-version":              struct{}{},
+		"tls-cipher-suites":            struct{}{},
+		"tls-reload-interval":          struct{}{},
+		"tls-client-ca":                struct{}{},
+		"http2":                        struct{}{},
+		"h2c":                          struct{}{},
+		"tcp-listen-host":              struct{}{},
+		"proxy-protocol":               struct{}{},
+		"proxy-protocol-trusted-cidrs": struct{}{},
+		"trusted-proxies":              struct{}{},
+		"plugin-timeout":               struct{}{},
+		"proxy-timeout":                struct{}{},
+		"default-tcp-connect-timeout":  struct{}{},
+		"default-dns-timeout":          struct{}{},
+		"admin-listen":                 struct{}{},
+		"admin-on-internal":            struct{}{},
+		"health-listen":                struct{}{},
+		"upgrade-timeout":              struct{}{},
+		"metric-buffer-size":           struct{}{},
+		"metric-flush-interval":        struct{}{},
 	}
 
 	flagSets := map[string]*flag.FlagSet{
@@ -164,16 +233,122 @@ func parseFlags(options *core.Options) error {
 	options.RouterPluginArgs = flagSetToMap(flagSets["router"])
 	options.UseLocalRouter = *useLocalRouter
 
-	options.HTTPPublic = *httpPublic
-	options.HTTPPublicPort = *httpPublicPort
-	options.HTTPInternal = *httpInternal
-	options.HTTPInternalPort = *httpInternalPort
-	options.HTTPSPublic = *httpsPublic
-	options.HTTPSPublicPort = *httpsPublicPort
-	options.HTTPSInternal = *httpsInternal
-	options.HTTPSInternalPort = *httpsInternalPort
+	// build a listener for each address of each enabled protocol, falling
+	// back to listening on all interfaces on the protocol's port
+	listeners := []struct {
+		protocol  gatekeeper.Protocol
+		enabled   bool
+		port      uint
+		addresses string
+	}{
+		{gatekeeper.HTTPPublic, *httpPublic, *httpPublicPort, *httpPublicListen},
+		{gatekeeper.HTTPInternal, *httpInternal, *httpInternalPort, *httpInternalListen},
+		{gatekeeper.HTTPSPublic, *httpsPublic, *httpsPublicPort, *httpsPublicListen},
+		{gatekeeper.HTTPSInternal, *httpsInternal, *httpsInternalPort, *httpsInternalListen},
+	}
+
+	for _, listener := range listeners {
+		if !listener.enabled {
+			continue
+		}
+
+		addresses := splitList(listener.addresses)
+		if len(addresses) == 0 {
+			addresses = []string{fmt.Sprintf(":%d", listener.port)}
+		}
+
+		for _, address := range addresses {
+			config, err := core.ParseListenerConfig(listener.protocol, address)
+			if err != nil {
+				return err
+			}
+			options.Listeners = append(options.Listeners, config)
+		}
+	}
+
+	tlsCertificates, err := core.ParseTLSCertificates(splitList(*tlsCerts), splitList(*tlsKeys))
+	if err != nil {
+		return err
+	}
+	options.TLSCertificates = tlsCertificates
+
+	options.TLSMinVersion, err = core.ParseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		return err
+	}
+
+	options.TLSCipherSuites, err = core.ParseCipherSuites(splitList(*tlsCipherSuites))
+	if err != nil {
+		return err
+	}
+	options.TLSReloadInterval = *tlsReloadInterval
+	options.TLSClientCAFile = *tlsClientCA
+	options.HTTP2 = *http2
+	options.H2C = *h2c
+	if options.HTTP2 {
+		if err := core.ValidateHTTP2CipherSuites(options.TLSCipherSuites, options.TLSMinVersion); err != nil {
+			return err
+		}
+	}
+
+	if *adminListen != "" {
+		config, err := core.ParseListenerConfig(gatekeeper.HTTPInternal, *adminListen)
+		if err != nil {
+			return err
+		}
+		options.AdminListener = &config
+	}
+	options.AdminOnInternal = *adminOnInternal
+
+	if *healthListen != "" {
+		config, err := core.ParseListenerConfig(gatekeeper.HTTPInternal, *healthListen)
+		if err != nil {
+			return err
+		}
+		options.HealthListener = &config
+	}
+	options.TCPListenHost = *tcpListenHost
+
+	// enable the PROXY protocol on the listeners of each given protocol
+	proxyProtocols, err := gatekeeper.ParseProtocols(splitList(*proxyProtocol))
+	if err != nil {
+		return err
+	}
+	if len(proxyProtocols) > 0 {
+		trustedCIDRs, err := core.ParseCIDRs(splitList(*proxyProtocolTrustedCIDRs))
+		if err != nil {
+			return err
+		}
+		if len(trustedCIDRs) == 0 {
+			return core.ProxyProtocolTrustedCIDRsRequiredError
+		}
+		proxyProtocolConfig := &core.ProxyProtocolConfig{
+			TrustedCIDRs: trustedCIDRs,
+		}
+
+		for idx, listener := range options.Listeners {
+			if slices.Contains(proxyProtocols, listener.Protocol) {
+				options.Listeners[idx].ProxyProtocol = proxyProtocolConfig
+			}
+		}
+		if slices.Contains(proxyProtocols, gatekeeper.TCP) {
+			options.TCPProxyProtocol = proxyProtocolConfig
+		}
+	}
+
+	trustedProxyCIDRs, err := core.ParseCIDRs(splitList(*trustedProxies))
+	if err != nil {
+		return err
+	}
+	for idx := range options.Listeners {
+		options.Listeners[idx].TrustedProxies = trustedProxyCIDRs
+	}
+
 	options.DefaultProxyTimeout = *proxyTimeout
+	options.DefaultTCPConnectTimeout = *tcpConnectTimeout
+	options.DefaultDNSTimeout = *dnsTimeout
 	options.PluginTimeout = *pluginTimeout
+	options.UpgradeTimeout = *upgradeTimeout
 
 	options.MetricBufferSize = *metricBufferSize
 	options.MetricFlushInterval = *metricFlushInterval
@@ -207,9 +382,31 @@ func main() {
 
 	go func() {
 		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-		<-signals
-		stop()
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+		for sig := range signals {
+			// SIGHUP reloads certificates and the client CA bundle,
+			// leaving the app running
+			if sig == syscall.SIGHUP {
+				if err := app.Reload(); err != nil {
+					log.Println(err)
+				}
+				continue
+			}
+
+			// SIGUSR2 hands the listeners to a newly exec'd gatekeeper
+			// process, draining and stopping this one once the new
+			// process is ready. If the upgrade fails, this process
+			// keeps serving.
+			if sig == syscall.SIGUSR2 {
+				if err := app.Upgrade(); err != nil {
+					log.Println(err)
+					continue
+				}
+			}
+
+			stop()
+			return
+		}
 	}()
 
 	// Start and run the application. This blocks
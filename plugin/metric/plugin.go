@@ -26,6 +26,14 @@ type Plugin interface {
 	UpstreamMetric(*gatekeeper.UpstreamMetric) error
 }
 
+// ConnectionMetricPlugin is implemented by plugins which receive the
+// ConnectionMetrics of TCP upstreams. It is optional, so that plugins written
+// before TCP upstreams existed continue to build; plugins which don't
+// implement it are not sent connection metrics.
+type ConnectionMetricPlugin interface {
+	ConnectionMetric(*gatekeeper.ConnectionMetric) error
+}
+
 // PluginClient in this case is the gatekeeper/core application. PluginClient
 // is the interface that the user of this plugin sees and is simply a wrapper
 // around *RPCClient. This is merely a wrapper which returns a clean interface
@@ -39,6 +47,7 @@ type PluginClient interface {
 	WritePluginMetrics([]*gatekeeper.PluginMetric) []error
 	WriteRequestMetrics([]*gatekeeper.RequestMetric) []error
 	WriteUpstreamMetrics([]*gatekeeper.UpstreamMetric) []error
+	WriteConnectionMetrics([]*gatekeeper.ConnectionMetric) []error
 }
 
 func NewPluginClient(rpcClient *RPCClient, client *plugin.Client) PluginClient {
@@ -87,3 +96,14 @@ func (p *pluginClient) WriteUpstreamMetrics(metrics []*gatekeeper.UpstreamMetric
 	}
 	return nil
 }
+
+func (p *pluginClient) WriteConnectionMetrics(metrics []*gatekeeper.ConnectionMetric) []error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if errs := p.pluginRPC.ConnectionMetric(metrics); errs != nil {
+		return gatekeeper.ErrorsToErrors(errs)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package metric
 
 import (
 	"net/rpc"
+	"strings"
 
 	"github.com/hashicorp/go-plugin"
 	"github.com/jonmorehouse/gatekeeper/gatekeeper"
@@ -43,6 +44,13 @@ type UpstreamMetricResp struct {
 	Errs []*gatekeeper.Error
 }
 
+type ConnectionMetricArgs struct {
+	Metrics []*gatekeeper.ConnectionMetric
+}
+type ConnectionMetricResp struct {
+	Errs []*gatekeeper.Error
+}
+
 // PluginRPC is a representation of the Plugin interface that is RPC safe. It
 // embeds an internal.BasePluginRPC which handles the basic RPC client
 // communications of the `Start`, `Stop`, `Configure` and `Heartbeat` methods.
@@ -105,6 +113,24 @@ func (c *RPCClient) UpstreamMetric(metrics []*gatekeeper.UpstreamMetric) []*gate
 	return callResp.Errs
 }
 
+func (c *RPCClient) ConnectionMetric(metrics []*gatekeeper.ConnectionMetric) []*gatekeeper.Error {
+	callArgs := ConnectionMetricArgs{
+		Metrics: metrics,
+	}
+	callResp := ConnectionMetricResp{}
+
+	err := c.client.Call("Plugin.ConnectionMetric", &callArgs, &callResp)
+	if _, ok := err.(rpc.ServerError); ok && strings.HasPrefix(err.Error(), "rpc: can't find method") {
+		// plugins built before connection metrics existed don't serve
+		// them, and the metrics are dropped
+		return nil
+	} else if err != nil {
+		return []*gatekeeper.Error{gatekeeper.NewError(err)}
+	}
+
+	return callResp.Errs
+}
+
 type RPCServer struct {
 	impl   Plugin
 	broker *plugin.MuxBroker
@@ -172,6 +198,25 @@ func (s *RPCServer) UpstreamMetric(args *UpstreamMetricArgs, resp *UpstreamMetri
 	return nil
 }
 
+// ConnectionMetric drops the metrics of plugins which don't implement the
+// optional ConnectionMetricPlugin interface
+func (s *RPCServer) ConnectionMetric(args *ConnectionMetricArgs, resp *ConnectionMetricResp) error {
+	impl, ok := s.impl.(ConnectionMetricPlugin)
+	if !ok {
+		return nil
+	}
+
+	errs := make([]*gatekeeper.Error, 0, len(args.Metrics))
+	for _, metric := range args.Metrics {
+		if err := impl.ConnectionMetric(metric); err != nil {
+			errs = append(errs, gatekeeper.NewError(err))
+		}
+	}
+
+	resp.Errs = errs
+	return nil
+}
+
 func (c *RPCClient) EventMetric(metrics []*gatekeeper.EventMetric) []*gatekeeper.Error {
 	callArgs := EventMetricArgs{
 		Metrics: metrics,
@@ -193,6 +193,49 @@ func (d *dockerServices) resolveService(container types.Container, image types.I
 		upstream.Timeout = d.defaultTimeout
 	}
 
+	// parse the port which tcp upstreams are bound to
+	upstreamPort, ok := labels["gatekeeper:port"]
+	if ok {
+		port, err := strconv.ParseUint(upstreamPort, 10, 16)
+		if err != nil {
+			return nil, nil, err
+		}
+		upstream.Port = uint(port)
+	}
+
+	// parse the PROXY protocol version sent to backends
+	proxyProtocol, ok := labels["gatekeeper:proxy_protocol"]
+	if ok {
+		version, err := strconv.ParseUint(proxyProtocol, 10, 8)
+		if err != nil {
+			return nil, nil, err
+		}
+		upstream.ProxyProtocol = uint(version)
+	}
+
+	// parse the client certificate policy
+	clientAuth, err := gatekeeper.ParseClientAuth(labels["gatekeeper:client_auth"])
+	if err != nil {
+		return nil, nil, err
+	}
+	upstream.ClientAuth = clientAuth
+
+	// parse the TLS settings for https backends
+	upstream.BackendTLS = gatekeeper.BackendTLS{
+		CAFile:     labels["gatekeeper:backend_tls_ca_file"],
+		CertFile:   labels["gatekeeper:backend_tls_cert_file"],
+		KeyFile:    labels["gatekeeper:backend_tls_key_file"],
+		ServerName: labels["gatekeeper:backend_tls_server_name"],
+	}
+	insecureSkipVerify, ok := labels["gatekeeper:backend_tls_insecure_skip_verify"]
+	if ok {
+		skip, err := strconv.ParseBool(insecureSkipVerify)
+		if err != nil {
+			return nil, nil, err
+		}
+		upstream.BackendTLS.InsecureSkipVerify = skip
+	}
+
 	// parse extra config as json into the upstream.Extra field
 	extra, ok := labels["gatekeeper:extra"]
 	if ok {
@@ -201,6 +244,25 @@ func (d *dockerServices) resolveService(container types.Container, image types.I
 		}
 	}
 
+	// parse match rules as json, eg: [{"headers": [{"name": "X-Api-Version", "value": "2"}]}]
+	rules, ok := labels["gatekeeper:rules"]
+	if ok {
+		if err := json.Unmarshal([]byte(rules), &(upstream.Rules)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// parse the traffic split the upstream is in, and its weight in it
+	upstream.Split = labels["gatekeeper:split"]
+	weight, ok := labels["gatekeeper:weight"]
+	if ok {
+		parsed, err := strconv.ParseUint(weight, 10, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+		upstream.Weight = uint(parsed)
+	}
+
 	// resolve the backendID from either a label or the container ID
 	backendID, ok := labels["gatekeeper:backend_id"]
 	if !ok {
@@ -120,18 +120,29 @@ func (p *plugin) RequestMetric(metric *gatekeeper.RequestMetric) error {
 		"backend.id:" + string(metric.Backend.ID),
 		"backend.address:" + metric.Backend.Address,
 	}
+	if metric.Split != "" {
+		tags = append(tags, "split:"+metric.Split)
+	}
 
 	// latencies
 	p.statsd.TimeInMilliseconds("request.latency", milliseconds(metric.Latency), tags, p.config.SampleRate)
 	p.statsd.TimeInMilliseconds("request.internal_latency", milliseconds(metric.InternalLatency), tags, p.config.SampleRate)
 	p.statsd.TimeInMilliseconds("request.dns_lookup_latency", milliseconds(metric.DNSLookupLatency), tags, p.config.SampleRate)
 	p.statsd.TimeInMilliseconds("request.tcp_connect_latency", milliseconds(metric.TCPConnectLatency), tags, p.config.SampleRate)
+	p.statsd.TimeInMilliseconds("request.tls_handshake_latency", milliseconds(metric.TLSHandshakeLatency), tags, p.config.SampleRate)
+	p.statsd.TimeInMilliseconds("request.time_to_first_byte", milliseconds(metric.TimeToFirstByte), tags, p.config.SampleRate)
 	p.statsd.TimeInMilliseconds("request.proxy_latency", milliseconds(metric.ProxyLatency), tags, p.config.SampleRate)
 
 	// connection meta information
-	p.statsd.Count("request.dns_lookup", 1.0, tags, p.config.SampleRate)
-	p.statsd.Count("request.conn_reused", 1.0, tags, p.config.SampleRate)
-	p.statsd.Count("request.conn_was_idle", 1.0, tags, p.config.SampleRate)
+	if metric.DNSLookup {
+		p.statsd.Count("request.dns_lookup", 1.0, tags, p.config.SampleRate)
+	}
+	if metric.ConnReused {
+		p.statsd.Count("request.conn_reused", 1.0, tags, p.config.SampleRate)
+	}
+	if metric.ConnWasIdle {
+		p.statsd.Count("request.conn_was_idle", 1.0, tags, p.config.SampleRate)
+	}
 	p.statsd.TimeInMilliseconds("request.conn_idle_time", milliseconds(metric.ConnIdleTime), tags, p.config.SampleRate)
 
 	// plugin latencies
@@ -141,6 +152,25 @@ func (p *plugin) RequestMetric(metric *gatekeeper.RequestMetric) error {
 	p.statsd.TimeInMilliseconds("request.response_modifier_latency", milliseconds(metric.ResponseModifierLatency), tags, p.config.SampleRate)
 	p.statsd.TimeInMilliseconds("request.error_response_modifier_latency", milliseconds(metric.ErrorResponseModifierLatency), tags, p.config.SampleRate)
 
+	// upgraded connections, such as websockets
+	if metric.Upgraded {
+		p.statsd.TimeInMilliseconds("request.upgrade_duration", milliseconds(metric.UpgradeDuration), tags, p.config.SampleRate)
+		p.statsd.Histogram("request.upgrade_bytes_from_client", float64(metric.BytesFromClient), tags, p.config.SampleRate)
+		p.statsd.Histogram("request.upgrade_bytes_from_backend", float64(metric.BytesFromBackend), tags, p.config.SampleRate)
+	}
+
+	if metric.ClientCanceled {
+		p.statsd.Count("request.client_canceled", 1, tags, p.config.SampleRate)
+	}
+
+	if metric.BackendTLSHandshakeFailed {
+		p.statsd.Count("request.backend_tls_handshake_failed", 1, tags, p.config.SampleRate)
+	}
+
+	if metric.GRPC {
+		p.statsd.Count("request.grpc", 1, append(tags, fmt.Sprintf("grpc_status:%d", metric.GRPCStatus)), p.config.SampleRate)
+	}
+
 	if metric.Response.Error != nil {
 		p.statsd.Count("request.error", 1, append(tags, "error:"+metric.Response.Error.Error()), p.config.SampleRate)
 	}
@@ -177,6 +207,33 @@ func (p *plugin) UpstreamMetric(metric *gatekeeper.UpstreamMetric) error {
 	return nil
 }
 
+func (p *plugin) ConnectionMetric(metric *gatekeeper.ConnectionMetric) error {
+	tags := []string{
+		"upstream.id:" + string(metric.Upstream.ID),
+		"upstream.name:" + metric.Upstream.Name,
+	}
+	if metric.Backend != nil {
+		tags = append(tags, "backend.id:"+string(metric.Backend.ID), "backend.address:"+metric.Backend.Address)
+	}
+
+	p.statsd.Count("connection.count", 1.0, tags, p.config.SampleRate)
+	if metric.ConnectError {
+		p.statsd.Count("connection.connect_error", 1.0, tags, p.config.SampleRate)
+		return nil
+	}
+
+	p.statsd.TimeInMilliseconds("connection.duration", milliseconds(metric.Duration), tags, p.config.SampleRate)
+	p.statsd.TimeInMilliseconds("connection.connect_latency", milliseconds(metric.ConnectLatency), tags, p.config.SampleRate)
+	p.statsd.TimeInMilliseconds("connection.load_balancer_latency", milliseconds(metric.LoadBalancerLatency), tags, p.config.SampleRate)
+	p.statsd.Histogram("connection.bytes_from_client", float64(metric.BytesFromClient), tags, p.config.SampleRate)
+	p.statsd.Histogram("connection.bytes_from_backend", float64(metric.BytesFromBackend), tags, p.config.SampleRate)
+
+	if metric.Error != nil {
+		p.statsd.Count("connection.error", 1, append(tags, "error:"+metric.Error.Error()), p.config.SampleRate)
+	}
+	return nil
+}
+
 func main() {
 	plugin := newPlugin()
 	if err := metrics_plugin.RunPlugin("", plugin); err != nil {
@@ -130,10 +130,84 @@ type upstream struct {
 	Timeout   time.Duration          `json:"timeout"`
 	Extra     map[string]interface{} `json:"extra"`
 
+	Port          uint   `json:"port"`
+	ProxyProtocol uint   `json:"proxy_protocol"`
+	ClientAuth    string `json:"client_auth"`
+	Split         string `json:"split"`
+	Weight        uint   `json:"weight"`
+
+	BackendTLS backendTLS `json:"backend_tls"`
+	Rules      []*rule    `json:"rules"`
+
 	// backends
 	Backends []*backend `json:"backends"`
 }
 
+// backendTLS is a JSON formatted representation of the gatekeeper.BackendTLS type
+type backendTLS struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// rule is a JSON formatted representation of the gatekeeper.Rule type
+type rule struct {
+	Methods []string     `json:"methods"`
+	Headers []*fieldRule `json:"headers"`
+	Query   []*fieldRule `json:"query"`
+	Cookies []*fieldRule `json:"cookies"`
+}
+
+type fieldRule struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Regex string `json:"regex"`
+}
+
+func toRules(rules []gatekeeper.Rule) []*rule {
+	serialized := make([]*rule, len(rules))
+	for idx, r := range rules {
+		serialized[idx] = &rule{
+			Methods: r.Methods,
+			Headers: toFieldRules(r.Headers),
+			Query:   toFieldRules(r.Query),
+			Cookies: toFieldRules(r.Cookies),
+		}
+	}
+	return serialized
+}
+
+func toFieldRules(rules []gatekeeper.FieldRule) []*fieldRule {
+	serialized := make([]*fieldRule, len(rules))
+	for idx, r := range rules {
+		serialized[idx] = &fieldRule{Name: r.Name, Value: r.Value, Regex: r.Regex}
+	}
+	return serialized
+}
+
+func parseRules(rules []*rule) []gatekeeper.Rule {
+	parsed := make([]gatekeeper.Rule, len(rules))
+	for idx, r := range rules {
+		parsed[idx] = gatekeeper.Rule{
+			Methods: r.Methods,
+			Headers: parseFieldRules(r.Headers),
+			Query:   parseFieldRules(r.Query),
+			Cookies: parseFieldRules(r.Cookies),
+		}
+	}
+	return parsed
+}
+
+func parseFieldRules(rules []*fieldRule) []gatekeeper.FieldRule {
+	parsed := make([]gatekeeper.FieldRule, len(rules))
+	for idx, r := range rules {
+		parsed[idx] = gatekeeper.FieldRule{Name: r.Name, Value: r.Value, Regex: r.Regex}
+	}
+	return parsed
+}
+
 // take a gatekeeper Upstream and return a serialized local Upstream that can be written out as JSON
 func toUpstream(u *gatekeeper.Upstream) *upstream {
 	protocols := make([]string, len(u.Protocols))
@@ -149,6 +223,15 @@ func toUpstream(u *gatekeeper.Upstream) *upstream {
 		Prefixes:  u.Prefixes,
 		Timeout:   u.Timeout,
 		Extra:     u.Extra,
+
+		Port:          u.Port,
+		ProxyProtocol: u.ProxyProtocol,
+		ClientAuth:    u.ClientAuth.String(),
+		Split:         u.Split,
+		Weight:        u.Weight,
+
+		BackendTLS: backendTLS(u.BackendTLS),
+		Rules:      toRules(u.Rules),
 	}
 }
 
@@ -159,6 +242,11 @@ func parseUpstream(u *upstream) (*gatekeeper.Upstream, []*gatekeeper.Backend, er
 		return nil, nil, err
 	}
 
+	clientAuth, err := gatekeeper.ParseClientAuth(u.ClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	upstream := &gatekeeper.Upstream{
 		ID:        gatekeeper.UpstreamID(u.ID),
 		Name:      u.Name,
@@ -167,6 +255,15 @@ func parseUpstream(u *upstream) (*gatekeeper.Upstream, []*gatekeeper.Backend, er
 		Prefixes:  u.Prefixes,
 		Timeout:   u.Timeout,
 		Extra:     u.Extra,
+
+		Port:          u.Port,
+		ProxyProtocol: u.ProxyProtocol,
+		ClientAuth:    clientAuth,
+		Split:         u.Split,
+		Weight:        u.Weight,
+
+		BackendTLS: gatekeeper.BackendTLS(u.BackendTLS),
+		Rules:      parseRules(u.Rules),
 	}
 
 	backends := make([]*gatekeeper.Backend, len(u.Backends))
@@ -92,11 +92,18 @@ func (*plugin) RequestMetric(metric *gatekeeper.RequestMetric) error {
 
 	// print out request metrics
 	log(fmt.Sprintf("metric.request.remote_addr value=%s", metric.Request.RemoteAddr))
+	log(fmt.Sprintf("metric.request.client_ip value=%s", metric.Request.ClientIP))
 	log(fmt.Sprintf("metric.request.method value=%s", metric.Request.Method))
 	log(fmt.Sprintf("metric.request.host value=%s", metric.Request.Host))
 	log(fmt.Sprintf("metric.request.prefix value=%s", metric.Request.Prefix))
 	log(fmt.Sprintf("metric.request.path value=%s", metric.Request.Path))
 	log(fmt.Sprintf("metric.request.upstream_match_type value=%s", metric.Request.UpstreamMatchType.String()))
+	if metric.Split != "" {
+		log(fmt.Sprintf("metric.request.split value=%s", metric.Split))
+	}
+	if cert := metric.Request.ClientCertificate; cert != nil {
+		log(fmt.Sprintf("metric.request.client_certificate subject=%s fingerprint=%s not_after=%s", cert.Subject, cert.Fingerprint, cert.NotAfter))
+	}
 	for k, vs := range metric.Request.Header {
 		for _, v := range vs {
 			log(fmt.Sprintf("metric.request.header %s=%s", k, v))
@@ -121,13 +128,15 @@ func (*plugin) RequestMetric(metric *gatekeeper.RequestMetric) error {
 	log(fmt.Sprintf("metric.request.internal_latency value=%d", metric.InternalLatency))
 	log(fmt.Sprintf("metric.request.dns_lookup_latency value=%d", metric.DNSLookupLatency))
 	log(fmt.Sprintf("metric.request.tcp_connect_latency value=%d", metric.TCPConnectLatency))
+	log(fmt.Sprintf("metric.request.tls_handshake_latency value=%d", metric.TLSHandshakeLatency))
+	log(fmt.Sprintf("metric.request.time_to_first_byte value=%d", metric.TimeToFirstByte))
 	log(fmt.Sprintf("metric.request.proxy_latency value=%d", metric.ProxyLatency))
 
 	// connection meta information
-	log(fmt.Sprintf("metric.request.dns_lookup value=%b", metric.DNSLookup))
-	log(fmt.Sprintf("metric.request.conn_reused value=%b", metric.ConnReused))
-	log(fmt.Sprintf("metric.request.conn_was_idle value=%b", metric.ConnReused))
-	log(fmt.Sprintf("metric.request.conn_idle_time value=%b", metric.ConnIdleTime))
+	log(fmt.Sprintf("metric.request.dns_lookup value=%t", metric.DNSLookup))
+	log(fmt.Sprintf("metric.request.conn_reused value=%t", metric.ConnReused))
+	log(fmt.Sprintf("metric.request.conn_was_idle value=%t", metric.ConnWasIdle))
+	log(fmt.Sprintf("metric.request.conn_idle_time value=%d", metric.ConnIdleTime))
 
 	// plugin latencies
 	log(fmt.Sprintf("metric.request.router_latency value=%s", metric.RouterLatency))
@@ -135,6 +144,19 @@ func (*plugin) RequestMetric(metric *gatekeeper.RequestMetric) error {
 	log(fmt.Sprintf("metric.request.response_modifier_latency value=%s", metric.ResponseModifierLatency))
 	log(fmt.Sprintf("metric.request.request_modifier_latency value=%s", metric.RequestModifierLatency))
 
+	log(fmt.Sprintf("metric.request.client_canceled value=%t", metric.ClientCanceled))
+	log(fmt.Sprintf("metric.request.backend_tls_handshake_failed value=%t", metric.BackendTLSHandshakeFailed))
+
+	if metric.GRPC {
+		log(fmt.Sprintf("metric.request.grpc_status value=%d", metric.GRPCStatus))
+	}
+
+	if metric.Upgraded {
+		log(fmt.Sprintf("metric.request.upgrade_duration value=%d", metric.UpgradeDuration))
+		log(fmt.Sprintf("metric.request.upgrade_bytes_from_client value=%d", metric.BytesFromClient))
+		log(fmt.Sprintf("metric.request.upgrade_bytes_from_backend value=%d", metric.BytesFromBackend))
+	}
+
 	if metric.Error != nil {
 		log(fmt.Sprintf("metric.request.error value=%s", metric.Error))
 		log(fmt.Sprintf("metric.request.error_response_modifier value=%s", metric.ErrorResponseModifierLatency))
@@ -151,6 +173,20 @@ func (*plugin) UpstreamMetric(metric *gatekeeper.UpstreamMetric) error {
 	return nil
 }
 
+func (*plugin) ConnectionMetric(metric *gatekeeper.ConnectionMetric) error {
+	msg := fmt.Sprintf("metric.connection upstream.name=%s upstream.id=%s client_address=%s listen_address=%s", metric.Upstream.Name, metric.Upstream.ID, metric.ClientAddress, metric.ListenAddress)
+	if metric.Backend != nil {
+		msg += fmt.Sprintf(" backend.id=%s backend.address=%s", metric.Backend.ID, metric.Backend.Address)
+	}
+
+	msg += fmt.Sprintf(" duration=%s connect_latency=%s load_balancer_latency=%s bytes_from_client=%d bytes_from_backend=%d connect_error=%t", metric.Duration, metric.ConnectLatency, metric.LoadBalancerLatency, metric.BytesFromClient, metric.BytesFromBackend, metric.ConnectError)
+	if metric.Error != nil {
+		msg += fmt.Sprintf(" error=%s", metric.Error)
+	}
+	log.Println(msg)
+	return nil
+}
+
 func main() {
 	if err := metric_plugin.RunPlugin("metric-logger", &plugin{}); err != nil {
 		log.Fatal(err)
@@ -17,15 +17,68 @@ import (
 // serviceDef represents an individual upstream configuration in a yaml
 // file. Specifically, this exposes
 type serviceDef struct {
-	ID           string                 `yaml:"id"`
-	Name         string                 `yaml:"name"`
-	Timeout      time.Duration          `yaml:"timeout"`
-	Protocols    []string               `yaml:"protocols"`
-	Prefixes     []string               `yaml:"prefixes"`
-	Hostnames    []string               `yaml:"hostnames"`
-	Extra        map[string]interface{} `yaml:"extra"`
-	Backends     []string               `yaml:"backends"`
-	BackendExtra map[string]interface{} `yaml:"backend_extra"`
+	ID            string                 `yaml:"id"`
+	Name          string                 `yaml:"name"`
+	Timeout       time.Duration          `yaml:"timeout"`
+	Protocols     []string               `yaml:"protocols"`
+	Prefixes      []string               `yaml:"prefixes"`
+	Hostnames     []string               `yaml:"hostnames"`
+	Extra         map[string]interface{} `yaml:"extra"`
+	Port          uint                   `yaml:"port"`
+	ProxyProtocol uint                   `yaml:"proxy_protocol"`
+	ClientAuth    string                 `yaml:"client_auth"`
+	Split         string                 `yaml:"split"`
+	Weight        uint                   `yaml:"weight"`
+	BackendTLS    backendTLSDef          `yaml:"backend_tls"`
+	Rules         []ruleDef              `yaml:"rules"`
+	Backends      []string               `yaml:"backends"`
+	BackendExtra  map[string]interface{} `yaml:"backend_extra"`
+}
+
+// backendTLSDef configures TLS for a service's https backends
+type backendTLSDef struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ruleDef narrows the requests which a service matches, eg:
+//
+//	rules:
+//	  - methods: [GET]
+//	    headers:
+//	      - name: X-Api-Version
+//	        value: "2"
+type ruleDef struct {
+	Methods []string       `yaml:"methods"`
+	Headers []fieldRuleDef `yaml:"headers"`
+	Query   []fieldRuleDef `yaml:"query"`
+	Cookies []fieldRuleDef `yaml:"cookies"`
+}
+
+type fieldRuleDef struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+}
+
+func (r ruleDef) rule() gatekeeper.Rule {
+	return gatekeeper.Rule{
+		Methods: r.Methods,
+		Headers: fieldRules(r.Headers),
+		Query:   fieldRules(r.Query),
+		Cookies: fieldRules(r.Cookies),
+	}
+}
+
+func fieldRules(defs []fieldRuleDef) []gatekeeper.FieldRule {
+	rules := make([]gatekeeper.FieldRule, len(defs))
+	for idx, def := range defs {
+		rules[idx] = gatekeeper.FieldRule(def)
+	}
+	return rules
 }
 
 type serviceDefs map[string]serviceDef
@@ -81,6 +134,11 @@ func syncServices(upstreams serviceDefs, container utils.ServiceContainer) error
 			return err
 		}
 
+		clientAuth, err := gatekeeper.ParseClientAuth(serviceDef.ClientAuth)
+		if err != nil {
+			return err
+		}
+
 		upstream := &gatekeeper.Upstream{
 			ID:        id,
 			Name:      name,
@@ -89,6 +147,16 @@ func syncServices(upstreams serviceDefs, container utils.ServiceContainer) error
 			Hostnames: serviceDef.Hostnames,
 			Prefixes:  serviceDef.Prefixes,
 			Extra:     serviceDef.Extra,
+			Port:      serviceDef.Port,
+
+			ProxyProtocol: serviceDef.ProxyProtocol,
+			ClientAuth:    clientAuth,
+			BackendTLS:    gatekeeper.BackendTLS(serviceDef.BackendTLS),
+			Split:         serviceDef.Split,
+			Weight:        serviceDef.Weight,
+		}
+		for _, ruleDef := range serviceDef.Rules {
+			upstream.Rules = append(upstream.Rules, ruleDef.rule())
 		}
 
 		if err := container.AddUpstream(upstream); err != nil {